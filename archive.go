@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// ArchiveWriter is where the converted files of a folder are written to before they are packaged. Locations are
+// relative to the root of the archive and slash separated.
+type ArchiveWriter interface {
+	// Create returns a writer for the file at the location, replacing it if it already exists.
+	Create(name string) (ArchiveFile, error)
+
+	// Link adds an existing file from the input at the location.
+	Link(name string, input fs.FS, source string) error
+
+	// Open returns a reader for a file that was added to the archive.
+	Open(name string) (io.ReadCloser, error)
+
+	// Exists returns true if a file was added at the location.
+	Exists(name string) bool
+
+	// Files returns the location of every file that was added, sorted.
+	Files() []string
+}
+
+// ArchiveFile is a file being written to an archive. It is only added once Close succeeds, and Abort discards it, so
+// a file that failed to be written isn't packaged half-written.
+type ArchiveFile interface {
+	io.WriteCloser
+
+	// Abort discards the file, unless it was already closed. Writers defer it so every early return discards the file.
+	Abort()
+}
+
+// MemoryArchive keeps written files in memory and linked files in the input until they are zipped (see WriteZip),
+// so nothing is written to the output folder until the archive is complete.
+type MemoryArchive struct {
+	files map[string]memoryFile
+}
+
+type memoryFile struct {
+	data []byte
+
+	// input and source are where to read the file from, if it was linked instead of written.
+	input  fs.FS
+	source string
+}
+
+type memoryWriter struct {
+	bytes.Buffer
+	archive *MemoryArchive
+	name    string
+	done    bool
+}
+
+func NewMemoryArchive() *MemoryArchive {
+	return &MemoryArchive{files: map[string]memoryFile{}}
+}
+
+func (a *MemoryArchive) Create(name string) (ArchiveFile, error) {
+	return &memoryWriter{archive: a, name: name}, nil
+}
+
+func (w *memoryWriter) Close() error {
+	if !w.done {
+		w.done = true
+		w.archive.files[w.name] = memoryFile{data: w.Bytes()}
+	}
+	return nil
+}
+
+func (w *memoryWriter) Abort() {
+	w.done = true
+}
+
+func (a *MemoryArchive) Link(name string, input fs.FS, source string) error {
+	if !FileExistsIn(input, source) {
+		return fs.ErrNotExist
+	}
+	a.files[name] = memoryFile{input: input, source: FSPath(source)}
+	return nil
+}
+
+func (a *MemoryArchive) Open(name string) (io.ReadCloser, error) {
+	f, ok := a.files[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	if f.input != nil {
+		return f.input.Open(f.source)
+	}
+	return io.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+func (a *MemoryArchive) Exists(name string) bool {
+	_, ok := a.files[name]
+	return ok
+}
+
+func (a *MemoryArchive) Files() []string {
+	names := make([]string, 0, len(a.files))
+	for name := range a.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FolderArchive writes files straight into a folder, for when the output isn't zipped (-no-zip).
+type FolderArchive struct {
+	Path string
+}
+
+func (a FolderArchive) location(name string) string {
+	return filepath.Join(filepath.FromSlash(a.Path), filepath.FromSlash(name))
+}
+
+// folderFile is a file written by a FolderArchive. It is removed if it's aborted or can't be closed.
+type folderFile struct {
+	*os.File
+	done bool
+}
+
+func (a FolderArchive) Create(name string) (ArchiveFile, error) {
+	if e := os.MkdirAll(filepath.Dir(a.location(name)), 0755); e != nil {
+		return nil, e
+	}
+	f, e := os.Create(a.location(name))
+	if e != nil {
+		return nil, e
+	}
+	return &folderFile{File: f}, nil
+}
+
+func (f *folderFile) Close() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	if e := f.File.Close(); e != nil {
+		_ = os.Remove(f.Name())
+		return e
+	}
+	return nil
+}
+
+func (f *folderFile) Abort() {
+	if !f.done {
+		f.done = true
+		_ = f.File.Close()
+		_ = os.Remove(f.Name())
+	}
+}
+
+func (a FolderArchive) Link(name string, input fs.FS, source string) error {
+	return copyFile(input, source, a.location(name))
+}
+
+func (a FolderArchive) Open(name string) (io.ReadCloser, error) {
+	return os.Open(a.location(name))
+}
+
+func (a FolderArchive) Exists(name string) bool {
+	return FileExists(a.location(name))
+}
+
+func (a FolderArchive) Files() []string {
+	names := make([]string, 0)
+	_ = filepath.Walk(filepath.FromSlash(a.Path), func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if rel, e := filepath.Rel(filepath.FromSlash(a.Path), filePath); e == nil {
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names
+}
+
+// copyInArchive copies a file that was added to the archive to another location.
+func copyInArchive(archive ArchiveWriter, source string, destination string) error {
+	in, e := archive.Open(source)
+	if e != nil {
+		return e
+	}
+	defer in.Close()
+	out, e := archive.Create(destination)
+	if e != nil {
+		return e
+	}
+	defer out.Abort()
+	if _, e = io.Copy(out, in); e != nil {
+		return e
+	}
+	return out.Close()
+}
+
+// isTopLevel returns true if the location is in the root of the archive.
+func isTopLevel(name string) bool {
+	return path.Dir(name) == "."
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/fs"
+	"math"
+	"path"
+	"path/filepath"
+	"strings"
+
+	_ "golang.org/x/image/bmp"
+)
+
+// ArtworkKind is the header an artwork image was defined by.
+type ArtworkKind string
+
+const (
+	StageFileArtwork ArtworkKind = "stagefile"
+	BannerArtwork    ArtworkKind = "banner"
+	BackBMPArtwork   ArtworkKind = "backbmp"
+	PreviewArtwork   ArtworkKind = "preview"
+)
+
+// ImageExtensions are the extensions tried, in order, when an image referenced by the chart doesn't exist.
+var ImageExtensions = []string{".png", ".jpg", ".jpeg", ".bmp", ".gif"}
+
+// Artwork is an image defined by one of the chart's artwork headers.
+type Artwork struct {
+	// Kind is which header defined this image.
+	Kind ArtworkKind `json:"kind"`
+
+	// File is the location of the image, relative to the chart.
+	File string `json:"file"`
+
+	// Width of the image in pixels. 0 if the image couldn't be read.
+	Width int `json:"width"`
+
+	// Height of the image in pixels. 0 if the image couldn't be read.
+	Height int `json:"height"`
+}
+
+// SearchForImageFile corrects the extension of an image file, the same way SearchForSoundFile does for audio.
+// Returns the requested file if it exists, or nothing if no alternative was found either.
+func SearchForImageFile(input fs.FS, requested string) string {
+	if FileExistsIn(input, requested) {
+		return requested
+	}
+	base := strings.TrimSuffix(requested, filepath.Ext(requested))
+	for _, ext := range ImageExtensions {
+		alt := base + ext
+		if FileExistsIn(input, alt) {
+			return alt
+		}
+	}
+	return ""
+}
+
+// IsImageFile returns true if the file has an extension found in ImageExtensions.
+func IsImageFile(file string) bool {
+	ext := strings.ToLower(path.Ext(file))
+	for _, e := range ImageExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveArtworkHeader reads the file name of an artwork header (e.g. #STAGEFILE), starting at offset,
+// and resolves it to an existing image. Returns an empty string if the header was invalid or the image is missing.
+// Missing images are added to the chart's asset report.
+func (conf *ProgramConfig) resolveArtworkHeader(fileData *BMSFileData, input fs.FS, line string, offset int, header string, lineIndex int) string {
+	if len(line) < offset+1 {
+		fileData.Warnings.Add(lineIndex, 0, "#%s is invalid, ignoring", header)
+		return ""
+	}
+	requested, e := BytesFromShiftJIS([]byte(strings.TrimSpace(line[offset:])))
+	if e != nil {
+		fileData.Warnings.Add(lineIndex, 0, "#%s filename decoding failed", header)
+		return ""
+	}
+	chosen := SearchForImageFile(input, requested)
+	if len(chosen) == 0 {
+		fileData.Warnings.Add(lineIndex, 0, "\"%s\" (#%s) wasn't found; ignoring", requested, header)
+		fileData.Assets.AddMissing("#"+header, requested, lineIndex)
+		return ""
+	}
+	if chosen != requested {
+		fileData.Warnings.Add(lineIndex, 0, "using \"%s\" for #%s instead of \"%s\"", chosen, header, requested)
+	}
+	return chosen
+}
+
+// GetImageSize returns the dimensions of an image without decoding all of it.
+func GetImageSize(input fs.FS, name string) (int, int, error) {
+	f, e := input.Open(FSPath(name))
+	if e != nil {
+		return 0, 0, e
+	}
+	defer f.Close()
+	c, _, e := image.DecodeConfig(f)
+	if e != nil {
+		return 0, 0, e
+	}
+	return c.Width, c.Height, nil
+}
+
+// CollectArtwork gathers every artwork image in the metadata, along with its dimensions.
+func CollectArtwork(input fs.FS, metadata BMSMetadata) []Artwork {
+	artwork := make([]Artwork, 0)
+	for _, a := range []Artwork{
+		{Kind: StageFileArtwork, File: metadata.StageFile},
+		{Kind: BackBMPArtwork, File: metadata.BackBMP},
+		{Kind: PreviewArtwork, File: metadata.PreviewImage},
+		{Kind: BannerArtwork, File: metadata.Banner},
+	} {
+		if len(a.File) == 0 {
+			continue
+		}
+		a.Width, a.Height, _ = GetImageSize(input, a.File)
+		artwork = append(artwork, a)
+	}
+	return artwork
+}
+
+// SelectBackground picks the artwork which is best suited as the background of the given file type.
+// Both osu! and Quaver display backgrounds in 16:9, so images closest to that aspect ratio with the highest
+// resolution win. Images which couldn't be read are only used when nothing else is available, in the
+// order stagefile, backbmp, preview, banner.
+func SelectBackground(artwork []Artwork, t fileType) string {
+	best := ""
+	bestScore := math.Inf(-1)
+	for i, a := range artwork {
+		score := -float64(i) - 100.0
+		if a.Width > 0 && a.Height > 0 {
+			score = scoreBackground(a, t)
+		}
+		if score > bestScore {
+			best = a.File
+			bestScore = score
+		}
+	}
+	return best
+}
+
+// scoreBackground rates an image as a background. Being off the target aspect ratio is penalised, and
+// resolution is rewarded up to 1080p.
+func scoreBackground(a Artwork, t fileType) float64 {
+	targetAspect := 16.0 / 9.0
+	targetPixels := 1920.0 * 1080.0
+	if t == Osu {
+		// osu! recommends 1366x768 backgrounds; anything larger is just more to load.
+		targetPixels = 1366.0 * 768.0
+	}
+	aspect := float64(a.Width) / float64(a.Height)
+	aspectPenalty := math.Abs(math.Log(aspect / targetAspect))
+	resolution := math.Min(float64(a.Width*a.Height)/targetPixels, 1.0)
+	return resolution - aspectPenalty
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// AssetReport lists problems with the files a chart references, so broken packs can be fixed.
+type AssetReport struct {
+	// Missing contains every file a header referenced that doesn't exist.
+	Missing []MissingAsset `json:"missing"`
+
+	// Unused contains every #WAV and #BMP definition that no channel uses.
+	Unused []UnusedDefinition `json:"unused"`
+}
+
+// MissingAsset is a file referenced by a header which couldn't be found.
+type MissingAsset struct {
+	// Header is the header which referenced the file, e.g. #WAV01.
+	Header string `json:"header"`
+
+	// File is the file which was referenced.
+	File string `json:"file"`
+
+	// Line is the line of the chart the header is on.
+	Line int `json:"line"`
+}
+
+// UnusedDefinition is a #WAV or #BMP definition that is never used by the chart.
+type UnusedDefinition struct {
+	// Header is the header of the definition, e.g. #BMP01.
+	Header string `json:"header"`
+
+	// File is the file the definition points to.
+	File string `json:"file"`
+}
+
+// AddMissing records a file referenced by the header which couldn't be found.
+func (r *AssetReport) AddMissing(header string, file string, line int) {
+	r.Missing = append(r.Missing, MissingAsset{
+		Header: strings.ToUpper(header),
+		File:   file,
+		Line:   line,
+	})
+}
+
+// FindUnusedDefinitions records every #WAV and #BMP definition whose code isn't in the used codes given.
+func (r *AssetReport) FindUnusedDefinitions(fileData *BMSFileData, usedSamples map[string]bool, usedImages map[string]bool) {
+	for i, code := range fileData.Audio.HexadecimalArray {
+		if !usedSamples[code] {
+			r.Unused = append(r.Unused, UnusedDefinition{
+				Header: "#WAV" + strings.ToUpper(code),
+				File:   fileData.Audio.StringArray[i],
+			})
+		}
+	}
+	codes := make([]string, 0, len(fileData.Indices.BGA))
+	for code := range fileData.Indices.BGA {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		// #BMP00 is implicitly used as the poor image.
+		if !usedImages[code] && code != "00" {
+			r.Unused = append(r.Unused, UnusedDefinition{
+				Header: "#BMP" + strings.ToUpper(code),
+				File:   fileData.Indices.BGA[code],
+			})
+		}
+	}
+}
+
+// GetReferencedFiles returns every file in the chart's folder which the chart references.
+func GetReferencedFiles(fileData BMSFileData) []string {
+	files := make([]string, 0)
+	files = append(files, fileData.Audio.StringArray...)
+	for _, a := range fileData.Metadata.Artwork {
+		files = append(files, a.File)
+	}
+	if len(fileData.Metadata.Preview) > 0 {
+		files = append(files, fileData.Metadata.Preview)
+	}
+	for _, f := range fileData.Indices.BGA {
+		files = append(files, f)
+	}
+	return files
+}
+
+// GetUsedSamples returns the index (1-based, see AudioData) of every sample played by a sound effect or note, in order.
+func GetUsedSamples(fileData BMSFileData) []int {
+	used := map[int]bool{}
+	for _, sfx := range fileData.SoundEffects {
+		used[sfx.Sample] = true
+	}
+	for _, objects := range fileData.HitObjects {
+		for _, obj := range objects {
+			if obj.KeySounds != nil {
+				used[obj.KeySounds.Sample] = true
+			}
+		}
+	}
+	samples := make([]int, 0, len(used))
+	for sample := range used {
+		if sample > 0 && sample <= len(fileData.Audio.StringArray) {
+			samples = append(samples, sample)
+		}
+	}
+	sort.Ints(samples)
+	return samples
+}
+
+// GetUsedFiles returns every file from the chart's folder which the converted chart actually uses: played samples,
+// the background and banner, and (for osu!) storyboard frames and the video. Files generated while packaging, like
+// animation frames and cropped images, aren't included.
+func (conf *ProgramConfig) GetUsedFiles(fileData BMSFileData) []string {
+	files := make([]string, 0)
+	for _, sample := range GetUsedSamples(fileData) {
+		files = append(files, fileData.Audio.StringArray[sample-1])
+	}
+	if len(fileData.Metadata.Background) > 0 {
+		files = append(files, fileData.Metadata.Background)
+	}
+	if conf.FileType == Quaver && len(fileData.Metadata.Banner) > 0 {
+		files = append(files, fileData.Metadata.Banner)
+	}
+	if conf.FileType == Osu {
+		for _, f := range fileData.BGAFrames {
+			if f.Animation == nil {
+				files = append(files, f.File)
+			}
+		}
+		if fileData.Video != nil {
+			files = append(files, fileData.Video.File)
+		}
+	}
+	return files
+}
+
+// FindUnreferencedFiles returns every file in the input (relative, slash separated) which isn't one of the known
+// files. Case and path separators are ignored when comparing.
+func FindUnreferencedFiles(input fs.FS, knownFiles []string) ([]string, error) {
+	known := map[string]bool{}
+	for _, f := range knownFiles {
+		known[normalizeAssetPath(f)] = true
+	}
+	unreferenced := make([]string, 0)
+	err := fs.WalkDir(input, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && !known[normalizeAssetPath(name)] {
+			unreferenced = append(unreferenced, name)
+		}
+		return nil
+	})
+	return unreferenced, err
+}
+
+// MatchesAnyPattern returns true if the file (relative, slash separated) or its name matches any of the glob
+// patterns. Case is ignored.
+func MatchesAnyPattern(file string, patterns []string) bool {
+	file = strings.ToLower(file)
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if ok, _ := path.Match(p, file); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, path.Base(file)); ok {
+			return true
+		}
+	}
+	return false
+}
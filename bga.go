@@ -0,0 +1,378 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"io/fs"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SwitchBGAMaxFrames is the most frames a single #SWBGA trigger can add to the storyboard.
+	SwitchBGAMaxFrames = 1000
+
+	// SwitchBGAMaxChartFrames is the most frames all #SWBGA animations of a chart can add to the storyboard.
+	SwitchBGAMaxChartFrames = 20000
+)
+
+var (
+	// bgaFrameChannels maps channels which show an image to the layer they're shown on.
+	bgaFrameChannels = map[string]Layer{
+		"04": Back,
+		"06": Poor,
+		"07": Front,
+		"0a": Front2,
+	}
+
+	// bgaOpacityChannels maps beatoraja's opacity channels to the layer they affect.
+	bgaOpacityChannels = map[string]Layer{
+		"0b": Back,
+		"0c": Front,
+		"0d": Front2,
+		"0e": Poor,
+	}
+
+	// bgaColorChannels maps channels referencing #ARGB definitions to the layer they affect.
+	bgaColorChannels = map[string]Layer{
+		"a1": Back,
+		"a2": Front,
+		"a3": Front2,
+		"a4": Poor,
+	}
+)
+
+const (
+	// BGASize is the width and height of the area BGA images are placed in, in pixels.
+	BGASize = 256
+
+	// CroppedBGADir is the folder (inside the output folder) where images cropped by #BGA are placed.
+	CroppedBGADir = "bmt_bga"
+)
+
+// BGADefinition is a region of a #BMP image, placed somewhere in the BGA area (#BGAxx).
+type BGADefinition struct {
+	// Source is the #BMP code of the image which is cropped.
+	Source string `json:"source"`
+
+	// X1, Y1, X2 and Y2 are the corners of the region to crop.
+	X1 int `json:"x1"`
+	Y1 int `json:"y1"`
+	X2 int `json:"x2"`
+	Y2 int `json:"y2"`
+
+	// DX and DY is where the top left corner of the region is placed.
+	DX int `json:"dx"`
+	DY int `json:"dy"`
+}
+
+// CroppedImage is an image which has to be generated while packaging, by cropping an existing image.
+type CroppedImage struct {
+	// Source is the location of the original image.
+	Source string
+
+	// Region is the area of Source to keep.
+	Region image.Rectangle
+}
+
+// File returns where the cropped image is placed, relative to the output folder. The location only depends on the
+// source and region, so charts sharing a definition share the image.
+func (c CroppedImage) File() string {
+	base := strings.NewReplacer("/", "_", "\\", "_", " ", "_").Replace(strings.TrimSuffix(c.Source, path.Ext(c.Source)))
+	return path.Join(CroppedBGADir, fmt.Sprintf("%s_%d_%d_%d_%d.png", base, c.Region.Min.X, c.Region.Min.Y, c.Region.Max.X, c.Region.Max.Y))
+}
+
+// SwitchBGA is an animation which plays when the player presses a key (#SWBGAxx).
+type SwitchBGA struct {
+	// FrameDuration is how long, in milliseconds, each frame is shown.
+	FrameDuration float64 `json:"frame_duration"`
+
+	// Duration is how long, in milliseconds, the animation plays for.
+	Duration float64 `json:"duration"`
+
+	// Channel is the note channel which triggers the animation.
+	Channel string `json:"channel"`
+
+	// Loop is true if the animation repeats until Duration has passed.
+	Loop bool `json:"loop"`
+
+	// Color is the tint applied to every frame.
+	Color ARGB `json:"color"`
+
+	// Pattern contains the #BMP codes of the frames, in order.
+	Pattern []string `json:"pattern"`
+
+	// Line is the line of the chart the animation is defined on.
+	Line int `json:"-"`
+}
+
+// ParseBGADefinition parses the value of a #BGAxx header (bb x1 y1 x2 y2 dx dy).
+func ParseBGADefinition(value string) (BGADefinition, bool) {
+	fields := strings.Fields(value)
+	if len(fields) != 7 || len(fields[0]) != 2 {
+		return BGADefinition{}, false
+	}
+	values := make([]int, 6)
+	for i, f := range fields[1:] {
+		v, e := strconv.Atoi(f)
+		if e != nil {
+			return BGADefinition{}, false
+		}
+		values[i] = v
+	}
+	return BGADefinition{
+		Source: strings.ToLower(fields[0]),
+		X1:     values[0],
+		Y1:     values[1],
+		X2:     values[2],
+		Y2:     values[3],
+		DX:     values[4],
+		DY:     values[5],
+	}, true
+}
+
+// ParseSwitchBGA parses the value of a #SWBGAxx header (fr:time:line:loop:a,r,g,b pattern).
+func ParseSwitchBGA(value string) (SwitchBGA, bool) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 || len(fields[1])%2 != 0 {
+		return SwitchBGA{}, false
+	}
+	parts := strings.SplitN(fields[0], ":", 5)
+	if len(parts) != 5 {
+		return SwitchBGA{}, false
+	}
+	fr, e1 := strconv.ParseFloat(parts[0], 64)
+	duration, e2 := strconv.ParseFloat(parts[1], 64)
+	c, ok := ParseARGB(parts[4])
+	if e1 != nil || e2 != nil || !ok || !(fr > 0.0) || math.IsInf(fr, 0) || math.IsNaN(duration) || math.IsInf(duration, 0) || len(parts[2]) != 2 {
+		return SwitchBGA{}, false
+	}
+	sw := SwitchBGA{
+		FrameDuration: fr,
+		Duration:      duration,
+		Channel:       strings.ToLower(parts[2]),
+		Loop:          parts[3] == "1",
+		Color:         c,
+	}
+	pattern := strings.ToLower(fields[1])
+	for i := 0; i < len(pattern)/2; i++ {
+		sw.Pattern = append(sw.Pattern, getHexadecimalPair(i, pattern))
+	}
+	return sw, true
+}
+
+// GetSwitchBGAFrames returns the frames of a #SWBGA animation triggered at the time given, up to maxFrames of them.
+// osu! storyboards can't react to key presses, so animations are played when a note in their channel should be hit.
+// Animations stop when the chart ends, although their first frame is always shown. The second value is false if
+// the animation was cut short because it had more than maxFrames frames.
+func GetSwitchBGAFrames(sw SwitchBGA, bga map[string]string, triggeredAt float64, chartEndTime float64, maxFrames int) ([]BGAFrame, bool) {
+	frames := make([]BGAFrame, 0)
+	end := triggeredAt + sw.Duration
+	// Without looping, the animation also stops once every frame was shown.
+	if patternEnd := triggeredAt + sw.FrameDuration*float64(len(sw.Pattern)); sw.Duration <= 0.0 || (!sw.Loop && patternEnd < end) {
+		end = patternEnd
+	}
+	if chartEndTime < end {
+		end = math.Max(chartEndTime, triggeredAt+sw.FrameDuration)
+	}
+	for i := 0; triggeredAt+float64(i)*sw.FrameDuration < end; i++ {
+		if i >= maxFrames {
+			return frames, false
+		}
+		file := bga[sw.Pattern[i%len(sw.Pattern)]]
+		if len(file) == 0 {
+			continue
+		}
+		c := sw.Color
+		frames = append(frames, BGAFrame{
+			StartTime: triggeredAt + float64(i)*sw.FrameDuration,
+			EndTime:   end,
+			File:      file,
+			Layer:     Switch,
+			Opacity:   1.0,
+			Color:     &c,
+		})
+	}
+	return frames, true
+}
+
+// GenerateCroppedImages crops every image in the list from the input, and adds them to the archive.
+func GenerateCroppedImages(input fs.FS, archive ArchiveWriter, crops []CroppedImage) error {
+	for _, c := range crops {
+		if archive.Exists(c.File()) {
+			continue
+		}
+		img, e := readImage(input, c.Source)
+		if e != nil {
+			return e
+		}
+		region := c.Region.Add(img.Bounds().Min).Intersect(img.Bounds())
+		if region.Empty() {
+			continue
+		}
+		cropped := image.NewRGBA(image.Rect(0, 0, region.Dx(), region.Dy()))
+		draw.Draw(cropped, cropped.Bounds(), img, region.Min, draw.Src)
+		if e = writeImage(archive, c.File(), cropped); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// ARGB is a color (and opacity) applied to a BGA layer, defined by #ARGBxx.
+type ARGB struct {
+	A int `json:"a"`
+	R int `json:"r"`
+	G int `json:"g"`
+	B int `json:"b"`
+}
+
+// BGAStateChange changes the opacity or color of a BGA layer from its start time onwards.
+type BGAStateChange struct {
+	StartTime float64
+	Layer     Layer
+
+	// Opacity is the new opacity (0.0-1.0), if this change was made by an opacity channel.
+	Opacity *float64
+
+	// Color is the new color, if this change was made by an ARGB channel.
+	Color *ARGB
+}
+
+// IsBGAChannel returns true if the channel is used for BGA frames, opacity or color.
+func IsBGAChannel(channel string) bool {
+	_, frame := bgaFrameChannels[channel]
+	_, opacity := bgaOpacityChannels[channel]
+	_, c := bgaColorChannels[channel]
+	return frame || opacity || c
+}
+
+func hasLayer(frames []BGAFrame, layer Layer) bool {
+	for _, f := range frames {
+		if f.Layer == layer {
+			return true
+		}
+	}
+	return false
+}
+
+// getBGAFrame returns a frame (without time or layer) showing the image for the given code. #BGA definitions take
+// precedence over #BMP images, and the region they crop is added to the chart's CroppedImages.
+func getBGAFrame(fileData *BMSFileData, code string) (BGAFrame, bool) {
+	if d, ok := fileData.Indices.BGADefinitions[code]; ok {
+		source := fileData.Indices.BGA[d.Source]
+		if len(source) == 0 {
+			return BGAFrame{}, false
+		}
+		crop := CroppedImage{
+			Source: source,
+			Region: image.Rect(d.X1, d.Y1, d.X2, d.Y2),
+		}
+		found := false
+		for _, c := range fileData.CroppedImages {
+			if c == crop {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fileData.CroppedImages = append(fileData.CroppedImages, crop)
+		}
+		return BGAFrame{
+			File:    crop.File(),
+			Opacity: 1.0,
+			Offset:  &image.Point{X: d.DX, Y: d.DY},
+		}, true
+	}
+	if file := fileData.Indices.BGA[code]; len(file) > 0 {
+		return BGAFrame{
+			File:    file,
+			Opacity: 1.0,
+		}, true
+	}
+	return BGAFrame{}, false
+}
+
+// ParseARGB parses the value of an #ARGBxx header (a,r,g,b from 0 to 255).
+func ParseARGB(value string) (ARGB, bool) {
+	parts := strings.Split(strings.TrimSpace(value), ",")
+	if len(parts) != 4 {
+		return ARGB{}, false
+	}
+	values := make([]int, 4)
+	for i, p := range parts {
+		v, e := strconv.Atoi(strings.TrimSpace(p))
+		if e != nil {
+			return ARGB{}, false
+		}
+		values[i] = ClampInt(v, 255, 0)
+	}
+	return ARGB{A: values[0], R: values[1], G: values[2], B: values[3]}, true
+}
+
+// ApplyBGAStateChanges sets the opacity and color of every frame to what its layer was set to when it appeared.
+// If the state of a layer changes while a frame is still showing, the frame is repeated at the time of the change
+// so that every frame has a constant opacity and color. Both slices must be sorted by start time.
+func ApplyBGAStateChanges(frames []BGAFrame, changes []BGAStateChange) []BGAFrame {
+	if len(changes) == 0 {
+		return frames
+	}
+	opacity := map[Layer]float64{}
+	colors := map[Layer]*ARGB{}
+	current := map[Layer]int{}
+	type layerTime struct {
+		layer Layer
+		time  float64
+	}
+	starts := map[layerTime]bool{}
+	for _, f := range frames {
+		starts[layerTime{f.Layer, f.StartTime}] = true
+	}
+
+	result := make([]BGAFrame, 0, len(frames))
+	c := 0
+	apply := func(f BGAFrame) BGAFrame {
+		if o, ok := opacity[f.Layer]; ok {
+			f.Opacity = o
+		}
+		if c, ok := colors[f.Layer]; ok {
+			f.Color = c
+		}
+		return f
+	}
+	flushChanges := func(until float64) {
+		for ; c < len(changes) && changes[c].StartTime <= until; c++ {
+			ch := changes[c]
+			if ch.Opacity != nil {
+				opacity[ch.Layer] = *ch.Opacity
+			}
+			if ch.Color != nil {
+				colors[ch.Layer] = ch.Color
+			}
+			// Repeat the frame currently showing on this layer with the new state, unless it's being replaced anyway.
+			i, ok := current[ch.Layer]
+			if !ok || starts[layerTime{ch.Layer, ch.StartTime}] {
+				continue
+			}
+			repeated := result[i]
+			repeated.StartTime = ch.StartTime
+			result = append(result, apply(repeated))
+			current[ch.Layer] = len(result) - 1
+		}
+	}
+	for _, f := range frames {
+		flushChanges(f.StartTime)
+		result = append(result, apply(f))
+		current[f.Layer] = len(result) - 1
+	}
+	flushChanges(math.Inf(1))
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].StartTime < result[j].StartTime
+	})
+	return result
+}
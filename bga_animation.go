@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// AnimationDir is the folder (inside the output folder) where frames of detected animations are copied to,
+	// since osu! needs animation frames to be named name0.png, name1.png, etc.
+	AnimationDir = "bmt_anim"
+
+	// AnimationMinFrames is the least amount of evenly spaced frames that are turned into an animation.
+	AnimationMinFrames = 3
+
+	// AnimationDelayTolerance is how far apart (in milliseconds) the delays between frames can be while still
+	// considering them evenly spaced.
+	AnimationDelayTolerance = 1.0
+)
+
+// BGAAnimation is a series of evenly spaced BGA frames which is written to osu! as a single Animation.
+type BGAAnimation struct {
+	// File is the location osu! is given for the animation, relative to the output folder. The frames themselves
+	// are File with the frame number inserted before the extension.
+	File string `json:"file"`
+
+	// Frames contains the images of the animation in order.
+	Frames []string `json:"frames"`
+
+	// FrameDelay is how long, in milliseconds, each frame is shown.
+	FrameDelay float64 `json:"frame_delay"`
+}
+
+// FrameFile is the location of the frame at the given index, as osu! expects it.
+func (a BGAAnimation) FrameFile(i int) string {
+	ext := path.Ext(a.File)
+	return strings.TrimSuffix(a.File, ext) + strconv.Itoa(i) + ext
+}
+
+// CoalesceBGAFrames reduces the amount of storyboard elements needed for the frames. Frames starting in the same
+// millisecond on the same layer are collapsed into the last one, since osu! can't tell them apart, and frames which
+// show the same image as the one before them are dropped, since it is still shown. Then, runs of at least
+// AnimationMinFrames evenly spaced frames on the same layer become a single animated frame.
+// Frames must be sorted by start time.
+func CoalesceBGAFrames(frames []BGAFrame) []BGAFrame {
+	byLayer := map[Layer][]BGAFrame{}
+	layers := make([]Layer, 0)
+	for _, f := range frames {
+		if _, ok := byLayer[f.Layer]; !ok {
+			layers = append(layers, f.Layer)
+		}
+		layerFrames := byLayer[f.Layer]
+		if n := len(layerFrames); n > 0 && int(layerFrames[n-1].StartTime) == int(f.StartTime) {
+			layerFrames[n-1] = f
+			continue
+		}
+		if n := len(layerFrames); n > 0 && isRepeatedFrame(layerFrames[n-1], f) {
+			continue
+		}
+		byLayer[f.Layer] = append(layerFrames, f)
+	}
+
+	result := make([]BGAFrame, 0, len(frames))
+	for _, l := range layers {
+		layerFrames := byLayer[l]
+		for i := 0; i < len(layerFrames); {
+			n := getAnimationLength(layerFrames[i:])
+			if n < AnimationMinFrames {
+				result = append(result, layerFrames[i])
+				i++
+				continue
+			}
+			result = append(result, newAnimationFrame(layerFrames[i:i+n]))
+			i += n
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].StartTime < result[j].StartTime
+	})
+	return result
+}
+
+// getAnimationLength returns how many frames, from the first, are evenly spaced and can be animated together.
+func getAnimationLength(frames []BGAFrame) int {
+	if len(frames) < 2 || !canAnimate(frames[0], frames[0]) {
+		return 1
+	}
+	delay := frames[1].StartTime - frames[0].StartTime
+	n := 1
+	// Compare against where the frame should be, so small differences don't add up over long animations.
+	for n < len(frames) && canAnimate(frames[0], frames[n]) {
+		if math.Abs(frames[n].StartTime-(frames[0].StartTime+delay*float64(n))) > AnimationDelayTolerance {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// canAnimate returns true if the frame can be part of an animation starting with the first frame given.
+func canAnimate(first BGAFrame, f BGAFrame) bool {
+	if IsVideoFile(f.File) || f.Animation != nil || f.Opacity != first.Opacity || f.EndTime != first.EndTime {
+		return false
+	}
+	if !strings.EqualFold(path.Ext(f.File), path.Ext(first.File)) {
+		return false
+	}
+	if (f.Offset == nil) != (first.Offset == nil) || (f.Offset != nil && *f.Offset != *first.Offset) {
+		return false
+	}
+	if (f.Color == nil) != (first.Color == nil) || (f.Color != nil && *f.Color != *first.Color) {
+		return false
+	}
+	return true
+}
+
+// isRepeatedFrame returns true if the frame shows the same image, the same way, as the previous frame on its layer,
+// which is still shown when it starts.
+func isRepeatedFrame(previous BGAFrame, f BGAFrame) bool {
+	return previous.File == f.File && previous.EndTime == 0 && canAnimate(previous, f)
+}
+
+// newAnimationFrame combines the frames into one animated frame. The location of the animation depends only on
+// its frames, so identical animations in different charts share their files.
+func newAnimationFrame(frames []BGAFrame) BGAFrame {
+	a := BGAAnimation{
+		FrameDelay: (frames[len(frames)-1].StartTime - frames[0].StartTime) / float64(len(frames)-1),
+	}
+	for _, f := range frames {
+		a.Frames = append(a.Frames, f.File)
+	}
+	h := sha1.Sum([]byte(strings.Join(a.Frames, "\n")))
+	a.File = path.Join(AnimationDir, hex.EncodeToString(h[:])[:12], "f"+strings.ToLower(path.Ext(frames[0].File)))
+
+	animated := frames[0]
+	animated.File = a.File
+	animated.EndTime = frames[len(frames)-1].EndTime
+	animated.Animation = &a
+	return animated
+}
+
+// GenerateAnimationFrames adds the frames of every animation to the archive where osu! expects them. Frames are
+// taken from the archive if they were generated there (e.g. cropped or transcoded images) and the input otherwise.
+// replaced (see TranscodeImages) is used to find transcoded frames, and has animations whose frames were transcoded
+// added.
+func GenerateAnimationFrames(input fs.FS, archive ArchiveWriter, animations []BGAAnimation, replaced map[string]string) error {
+	for _, a := range animations {
+		ext := path.Ext(a.File)
+		for i, frame := range a.Frames {
+			generated := ""
+			if r, ok := lookupReplaced(replaced, frame); ok {
+				generated = r
+				ext = path.Ext(r)
+			} else if archive.Exists(frame) {
+				generated = frame
+			}
+			destination := strings.TrimSuffix(a.FrameFile(i), path.Ext(a.File)) + ext
+			if archive.Exists(destination) {
+				continue
+			}
+			var e error
+			if len(generated) > 0 {
+				e = copyInArchive(archive, generated, destination)
+			} else {
+				e = archive.Link(destination, input, frame)
+			}
+			if e != nil {
+				return e
+			}
+		}
+		if ext != path.Ext(a.File) {
+			replaced[a.File] = strings.TrimSuffix(a.File, path.Ext(a.File)) + ext
+		}
+	}
+	return nil
+}
+
+// lookupReplaced finds what a file was replaced by, ignoring case and path separators like RewriteAssetReferences.
+func lookupReplaced(replaced map[string]string, file string) (string, bool) {
+	if r, ok := replaced[file]; ok {
+		return r, true
+	}
+	for k, v := range replaced {
+		if normalizeAssetPath(k) == normalizeAssetPath(file) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// GetAnimations returns every animation used by the frames.
+func GetAnimations(frames []BGAFrame) []BGAAnimation {
+	animations := make([]BGAAnimation, 0)
+	for _, f := range frames {
+		if f.Animation != nil {
+			animations = append(animations, *f.Animation)
+		}
+	}
+	return animations
+}
+
+// copyFile copies a file from the input to a location on disk.
+func copyFile(input fs.FS, source string, destination string) error {
+	if e := os.MkdirAll(filepath.Dir(filepath.FromSlash(destination)), 0755); e != nil {
+		return e
+	}
+	in, e := input.Open(FSPath(source))
+	if e != nil {
+		return e
+	}
+	defer in.Close()
+	out, e := os.Create(destination)
+	if e != nil {
+		return e
+	}
+	defer out.Close()
+	if _, e = io.Copy(out, in); e != nil {
+		// Like ArchiveFile.Abort, don't leave a half-copied file in the output.
+		_ = out.Close()
+		_ = os.Remove(destination)
+		return e
+	}
+	return out.Sync()
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/fatih/color"
+)
+
+// CacheFile is the name of the cache manifest, kept in the output directory.
+const CacheFile = ".bmt-cache.json"
+
+// cacheIgnoredOptions don't change what is written for a folder, so changing them doesn't invalidate the cache.
+var cacheIgnoredOptions = map[string]bool{
+	"i":              true,
+	"o":              true,
+	"v":              true,
+	"jobs":           true,
+	"report":         true,
+	"strict":         true,
+	"force":          true,
+	"watch":          true,
+	"watch-interval": true,
+}
+
+// Cache remembers which folders were converted, so folders which didn't change since the last conversion can be
+// skipped.
+type Cache struct {
+	Version string                `json:"version"`
+	Folders map[string]CacheEntry `json:"folders"`
+}
+
+// CacheEntry is the last conversion of a folder.
+type CacheEntry struct {
+	// Key is a hash of the folder's files and of the options it was converted with (see FolderKey).
+	Key string `json:"key"`
+
+	// Status is what happened when the folder was converted, which is reused if the folder is up to date.
+	Status ConversionStatus `json:"status"`
+}
+
+// LoadCache reads the cache manifest of the output directory. An empty cache is returned if there isn't one, or if
+// it was written by another version of the program.
+func LoadCache(output string) *Cache {
+	cache := &Cache{Version: Version, Folders: map[string]CacheEntry{}}
+	data, err := os.ReadFile(filepath.Join(filepath.FromSlash(output), CacheFile))
+	if err != nil {
+		return cache
+	}
+	var saved Cache
+	if err := json.Unmarshal(data, &saved); err != nil || saved.Version != Version || saved.Folders == nil {
+		return cache
+	}
+	return &saved
+}
+
+// Save writes the cache manifest to the output directory.
+func (c *Cache) Save(output string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	location := filepath.Join(filepath.FromSlash(output), CacheFile)
+	if err := os.WriteFile(location+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(location+".tmp", location)
+}
+
+// Prune removes the folders which aren't in the input anymore.
+func (c *Cache) Prune(songs []SongFolder) {
+	found := map[string]bool{}
+	for _, song := range songs {
+		found[song.Relative] = true
+	}
+	for relative := range c.Folders {
+		if !found[relative] {
+			delete(c.Folders, relative)
+		}
+	}
+}
+
+// UpToDate returns the status of the folder's last conversion if its key didn't change and everything it wrote
+// still exists.
+func (c *Cache) UpToDate(song SongFolder, key string) (ConversionStatus, bool) {
+	entry, ok := c.Folders[song.Relative]
+	if !ok || entry.Key != key || len(entry.Status.Outputs) == 0 {
+		return ConversionStatus{}, false
+	}
+	for _, o := range entry.Status.Outputs {
+		if !FileExists(o) {
+			return ConversionStatus{}, false
+		}
+	}
+	entry.Status.Cached = true
+	return entry.Status, true
+}
+
+// Cacheable returns true if the conversion can be reused when the folder doesn't change. Folders with charts that
+// failed to be read or written aren't, since the problem might not happen again.
+func Cacheable(status ConversionStatus) bool {
+	if status.Skip || len(status.Outputs) == 0 {
+		return false
+	}
+	for _, c := range status.Charts {
+		if c.Status == ChartFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// FolderKey returns a hash of everything the output of the folder depends on: the options, the name of the output,
+// and every file in the folder. Charts (and the folder config file) are hashed by content; other files are compared
+// by size and modification time (or their checksum in .zip files), so samples and videos don't have to be read.
+func (conf *ProgramConfig) FolderKey(song SongFolder) (string, error) {
+	input, closeInput, err := OpenSongFolder(song)
+	if err != nil {
+		return "", err
+	}
+	defer closeInput()
+
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "version %s\nname %s\n", Version, song.Name)
+	options := make([]string, 0, len(conf.options))
+	for name := range conf.options {
+		if !cacheIgnoredOptions[name] {
+			options = append(options, name)
+		}
+	}
+	sort.Strings(options)
+	for _, name := range options {
+		_, _ = fmt.Fprintf(h, "option %s=%s\n", name, conf.options[name])
+	}
+
+	err = fs.WalkDir(input, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(h, "file %s %d ", name, info.Size())
+		if IsChartFile(name) || name == FolderConfigFile {
+			if err := hashFile(h, input, name); err != nil {
+				return err
+			}
+		} else if header, ok := info.Sys().(*zip.FileHeader); ok {
+			_, _ = fmt.Fprintf(h, "%08x", header.CRC32)
+		} else {
+			_, _ = fmt.Fprintf(h, "%d", info.ModTime().UnixNano())
+		}
+		_, _ = fmt.Fprintln(h)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// FolderStamp returns a hash of the name, size and modification time of every file in the folder. Unlike FolderKey,
+// no file is read, so it's cheap enough to tell whether a folder changed every time the input is polled (see
+// WatchInput). Options aren't part of it, since they can't change while the program runs.
+func FolderStamp(song SongFolder) (string, error) {
+	input, closeInput, err := OpenSongFolder(song)
+	if err != nil {
+		return "", err
+	}
+	defer closeInput()
+
+	h := sha256.New()
+	err = fs.WalkDir(input, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(h, "file %s %d %d\n", name, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(h hash.Hash, input fs.FS, name string) error {
+	f, err := input.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(h, f)
+	return err
+}
+
+// ConvertFolderIfChanged converts the folder unless its output is up to date in the cache. The returned entry should
+// be saved in the cache, unless its key is empty.
+func (conf *ProgramConfig) ConvertFolderIfChanged(song SongFolder, folderIndex int, folderCount int, cache *Cache) (ConversionStatus, CacheEntry) {
+	key, err := conf.FolderKey(song)
+	if err != nil {
+		// The folder will most likely fail to convert as well, which is logged there.
+		return conf.ConvertFolder(song, folderIndex, folderCount), CacheEntry{}
+	}
+	if status, ok := cache.UpToDate(song, key); ok {
+		conf.Log.White("* [%d/%d] %s is up to date; skipping (use -force to convert it again)", folderIndex+1, folderCount, color.YellowString(song.Relative))
+		return status, CacheEntry{Key: key, Status: status}
+	}
+	status := conf.ConvertFolder(song, folderIndex, folderCount)
+	if !Cacheable(status) {
+		return status, CacheEntry{}
+	}
+	return status, CacheEntry{Key: key, Status: status}
+}
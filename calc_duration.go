@@ -1,5 +1,7 @@
 package main
 
+import "math"
+
 // GetBeatDuration returns the duration of a single beat of
 // a track, in 4/4 meter.
 func GetBeatDuration(bpm float64) float64 {
@@ -50,3 +52,20 @@ func GetTotalTrackDuration(initialBPM float64, data LocalTrackData) float64 {
 	stopTime := GetStopOffset(initialBPM, 100.0, data)
 	return baseLength + stopTime
 }
+
+// getChartEndTime returns when the last thing in the chart happens: the end of the last track, note or sound effect.
+func getChartEndTime(fileData BMSFileData) float64 {
+	end := 0.0
+	for t := range fileData.TimingPoints {
+		end = math.Max(end, t)
+	}
+	for _, objects := range fileData.HitObjects {
+		for _, o := range objects {
+			end = math.Max(end, math.Max(o.StartTime, o.EndTime))
+		}
+	}
+	for _, sfx := range fileData.SoundEffects {
+		end = math.Max(end, sfx.StartTime)
+	}
+	return end
+}
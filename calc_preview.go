@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sort"
+)
+
+// GetPreviewTime picks a song preview time for the chart. If the #PREVIEW audio is also used as a sample
+// somewhere in the chart, the first time it plays is used. Otherwise, the start of the densest window of notes
+// (PreviewWindow long) is returned. Returns -1 if the chart has no notes at all.
+func GetPreviewTime(fileData BMSFileData) float64 {
+	if len(fileData.Metadata.Preview) > 0 {
+		if t, ok := getPreviewSampleTime(fileData); ok {
+			return t
+		}
+	}
+	return GetDensestWindowStart(fileData.HitObjects, PreviewWindow)
+}
+
+// getPreviewSampleTime searches sound effects and key sounds for the first occurrence of the #PREVIEW file.
+func getPreviewSampleTime(fileData BMSFileData) (float64, bool) {
+	sample := 0
+	for i, s := range fileData.Audio.StringArray {
+		if s == fileData.Metadata.Preview {
+			sample = i + 1
+			break
+		}
+	}
+	if sample == 0 {
+		return 0.0, false
+	}
+
+	found := false
+	earliest := 0.0
+	for _, sfx := range fileData.SoundEffects {
+		if sfx.Sample == sample && (!found || sfx.StartTime < earliest) {
+			earliest = sfx.StartTime
+			found = true
+		}
+	}
+	for _, objects := range fileData.HitObjects {
+		for _, obj := range objects {
+			if obj.KeySounds != nil && obj.KeySounds.Sample == sample && (!found || obj.StartTime < earliest) {
+				earliest = obj.StartTime
+				found = true
+			}
+		}
+	}
+	return earliest, found
+}
+
+// GetDensestWindowStart returns the start time of the window (of the given length, in milliseconds) which
+// contains the most notes across all lanes. Ties are resolved by taking the earliest window.
+func GetDensestWindowStart(hitObjects map[int][]HitObject, window float64) float64 {
+	times := make([]float64, 0)
+	for _, objects := range hitObjects {
+		for _, obj := range objects {
+			times = append(times, obj.StartTime)
+		}
+	}
+	if len(times) == 0 {
+		return -1
+	}
+	sort.Float64s(times)
+
+	best, bestCount := 0, 0
+	end := 0
+	for start := range times {
+		for end < len(times) && times[end]-times[start] < window {
+			end++
+		}
+		if end-start > bestCount {
+			best = start
+			bestCount = end - start
+		}
+	}
+	return times[best]
+}
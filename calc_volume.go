@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// GetVolumeFromChannel converts the value of a volume channel (97/98), from 01 to FF, into a multiplier.
+func GetVolumeFromChannel(value int64) float64 {
+	return ClampFloat(float64(value)/255.0, 1.0, 0.0)
+}
+
+// ApplyVolumes sets the volume of every key sound and sound effect, taking the global volume (-vol), #VOLWAV, and
+// the last BGM (97) or key (98) volume change before the object into account.
+func (conf *ProgramConfig) ApplyVolumes(fileData *BMSFileData) {
+	sort.SliceStable(fileData.VolumeChanges, func(i, j int) bool {
+		return fileData.VolumeChanges[i].StartTime < fileData.VolumeChanges[j].StartTime
+	})
+	var bgmChanges, keyChanges []VolumeChange
+	for _, v := range fileData.VolumeChanges {
+		if v.Key {
+			keyChanges = append(keyChanges, v)
+		} else {
+			bgmChanges = append(bgmChanges, v)
+		}
+	}
+
+	base := float64(conf.Volume) * (fileData.VolWav / 100.0)
+	for i, sfx := range fileData.SoundEffects {
+		changes := bgmChanges
+		if sfx.FromKey {
+			changes = keyChanges
+		}
+		fileData.SoundEffects[i].Volume = getVolumeAt(base, changes, sfx.StartTime)
+	}
+	for lane, objects := range fileData.HitObjects {
+		for i, obj := range objects {
+			if obj.KeySounds == nil {
+				continue
+			}
+			fileData.HitObjects[lane][i].KeySounds.Volume = getVolumeAt(base, keyChanges, obj.StartTime)
+		}
+	}
+}
+
+// getVolumeAt multiplies the base volume by the last volume change at or before the time given.
+func getVolumeAt(base float64, changes []VolumeChange, t float64) int {
+	multiplier := 1.0
+	i := sort.Search(len(changes), func(i int) bool {
+		return changes[i].StartTime > t
+	})
+	if i > 0 {
+		multiplier = changes[i-1].Volume
+	}
+	return ClampInt(int(math.Round(base*multiplier)), 100, 0)
+}
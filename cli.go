@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// command is a subcommand of the program, which is given the arguments after its name and returns the exit code.
+type command struct {
+	name        string
+	usage       string
+	description string
+	run         func(args []string) int
+}
+
+var commands = []command{
+	{"convert", "convert [options]", "Convert BMS folders, .zip files or a single chart into osu! or Quaver maps. (default)", runConvert},
+	{"inspect", "inspect [options] <chart>...", "Print the headers and statistics of charts.", runInspect},
+	{"validate", "validate [options] <chart, folder or .zip>...", "Check charts for problems, without converting them.", runValidate},
+	{"info", "info [options] <chart>...", "Print the timing points of charts, as they will be converted.", runInfo},
+	{"serve", "serve [options]", "Convert zipped BMS folders uploaded over HTTP.", runServe},
+}
+
+// runCommand runs the subcommand given as the first argument. Without one (or if the first argument is a flag),
+// charts are converted like before subcommands existed.
+func runCommand(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runConvert(args)
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(args[1:])
+		}
+	}
+	if args[0] != "help" {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+	}
+	printUsage()
+	if args[0] == "help" {
+		return ExitOK
+	}
+	return ExitFatal
+}
+
+func printUsage() {
+	name := filepath.Base(os.Args[0])
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [options] [arguments]\n\nCommands:\n", name)
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-48s %s\n", c.usage, c.description)
+	}
+	fmt.Fprintf(os.Stderr, "\nUse %s <command> -h to see the options of a command.\n", name)
+}
+
+// parseCommand reads the options of a subcommand which takes files as arguments, and returns the config and the files.
+func parseCommand(name string, args []string) (*ProgramConfig, []string, bool) {
+	set := flag.NewFlagSet(name, flag.ExitOnError)
+	conf, err := ParseProgramConfig(set, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read the options: %s\n", err.Error())
+		return nil, nil, false
+	}
+	if set.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "No files given. Use %s help to see the usage of %s.\n", filepath.Base(os.Args[0]), name)
+		return nil, nil, false
+	}
+	return conf, set.Args(), true
+}
+
+// readChart reads a single chart file from disk, with the options of the folder config file next to it.
+func (conf *ProgramConfig) readChart(location string) (*BMSFileData, error) {
+	dir, file := filepath.Split(filepath.FromSlash(location))
+	if len(dir) == 0 {
+		dir = "."
+	}
+	if !IsChartFile(file) {
+		return nil, fmt.Errorf("%s isn't a .bms, .bme or .bml file", location)
+	}
+	input := os.DirFS(dir)
+	folderOptions, err := ReadFolderConfig(input)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", FolderConfigFile, err)
+	}
+	if folderOptions != nil {
+		if conf, err = conf.WithFolderConfig(folderOptions); err != nil {
+			return nil, fmt.Errorf("%s: %w", FolderConfigFile, err)
+		}
+	}
+	return conf.ReadFileData(input, file)
+}
+
+// sortedTimingPoints returns the times of the chart's timing points in order.
+func sortedTimingPoints(fileData *BMSFileData) []float64 {
+	times := make([]float64, 0, len(fileData.TimingPoints))
+	for t := range fileData.TimingPoints {
+		times = append(times, t)
+	}
+	sort.Float64s(times)
+	return times
+}
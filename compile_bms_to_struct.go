@@ -2,14 +2,12 @@ package main
 
 import (
 	"bufio"
-	"os"
-	"path"
-	"path/filepath"
+	"io/fs"
 	"regexp"
 	"strconv"
 	"strings"
 
-	"github.com/fatih/color"
+	"github.com/vysiondev/bmtranslator/diagnostics"
 )
 
 var (
@@ -18,8 +16,8 @@ var (
 
 // CompileBMSToStruct converts a BMS file into a struct (BMSFileData) which can then be interpreted by the rest
 // of the program. It does not do any position calculation, only makes the data readable.
-func (conf *ProgramConfig) CompileBMSToStruct(inputPath string, bmsFileName string) (*BMSFileData, error) {
-	file, err := os.Open(path.Join(inputPath, bmsFileName))
+func (conf *ProgramConfig) CompileBMSToStruct(input fs.FS, bmsFileName string) (*BMSFileData, error) {
+	file, err := input.Open(FSPath(bmsFileName))
 	if err != nil {
 		return nil, err
 	}
@@ -38,9 +36,13 @@ func (conf *ProgramConfig) CompileBMSToStruct(inputPath string, bmsFileName stri
 		TrackLines: map[int][]Line{},
 		HitObjects: map[int][]HitObject{},
 		Indices: IndexData{
-			BPMChanges: map[string]float64{},
-			Stops:      map[string]float64{},
-			BGA:        map[string]string{},
+			BPMChanges:     map[string]float64{},
+			Stops:          map[string]float64{},
+			BGA:            map[string]string{},
+			Text:           map[string]string{},
+			ARGB:           map[string]ARGB{},
+			BGADefinitions: map[string]BGADefinition{},
+			SwitchBGA:      map[string]SwitchBGA{},
 		},
 		Audio: AudioData{
 			StringArray:      make([]string, 0),
@@ -49,6 +51,8 @@ func (conf *ProgramConfig) CompileBMSToStruct(inputPath string, bmsFileName stri
 		SoundEffects: make([]SoundEffect, 0),
 		TimingPoints: map[float64]float64{},
 		StartingBPM:  DefaultStartingBPM,
+		VolWav:       100.0,
+		Warnings:     diagnostics.Collector{File: bmsFileName},
 	}
 
 	// Should be true if the value of #IF n is anything other than 2. Resets at the #END(IF) mark.
@@ -89,225 +93,255 @@ func (conf *ProgramConfig) CompileBMSToStruct(inputPath string, bmsFileName stri
 		if len(line) < 7 || line[6] != ':' {
 			if strings.HasPrefix(lineLower, "#player") {
 				if len(line) < 9 {
-					if conf.Verbose {
-						color.HiRed("* Player type cannot be determined. (Line: %d)", lineIndex)
-					}
-					return nil, nil
+					return nil, diagnostics.New(diagnostics.ErrInvalidPlayer, lineIndex, 0, "player type cannot be determined")
 				}
 				switch line[8] {
 				case '1':
 					break
 				case '2':
-					if conf.Verbose {
-						color.HiYellow("* Map specified #PLAYER 2; skipping")
-					}
-					return nil, nil
+					return nil, diagnostics.New(diagnostics.ErrUnsupportedPlayerMode, lineIndex, 9, "chart is for player 2 (#PLAYER 2)")
 				case '3':
-					if conf.Verbose {
-						color.HiYellow("* Double play mode; skipping")
-					}
-					return nil, nil
+					return nil, diagnostics.New(diagnostics.ErrUnsupportedPlayerMode, lineIndex, 9, "double play isn't supported (#PLAYER 3)")
 				default:
-					if conf.Verbose {
-						color.HiYellow("* Even though player header was defined, there was no valid input (Line: %d)", lineIndex)
-					}
-					return nil, nil
+					return nil, diagnostics.New(diagnostics.ErrInvalidPlayer, lineIndex, 9, "even though player header was defined, there was no valid input")
 				}
 			} else if strings.HasPrefix(lineLower, "#genre") {
 				if len(line) < 8 {
-					if conf.Verbose {
-						color.HiYellow("* #genre is invalid, ignoring (Line: %d)", lineIndex)
-					}
+					fileData.Warnings.Add(lineIndex, 0, "#genre is invalid, ignoring")
 					fileData.Metadata.Tags = "BMS"
 					continue
 				}
 				lineBytes := []byte(line[7:])
 				b, e := BytesFromShiftJIS(lineBytes)
 				if e != nil {
-					if conf.Verbose {
-						color.HiYellow("* #genre couldn't be converted via ShiftJIS (Line: %d)", lineIndex)
-					}
+					fileData.Warnings.Add(lineIndex, 0, "#genre couldn't be converted via ShiftJIS")
 				}
 				fileData.Metadata.Tags = b
 			} else if strings.HasPrefix(lineLower, "#subtitle") {
 				if len(line) < 11 {
-					if conf.Verbose {
-						color.HiYellow("* #subtitle is invalid, ignoring (Line: %d)", lineIndex)
-					}
+					fileData.Warnings.Add(lineIndex, 0, "#subtitle is invalid, ignoring")
 					continue
 				}
 				lineBytes := []byte(line[10:])
 				b, e := BytesFromShiftJIS(lineBytes)
 				if e != nil {
-					if conf.Verbose {
-						color.HiYellow("* #subtitle couldn't be converted via ShiftJIS (Line: %d)", lineIndex)
-					}
+					fileData.Warnings.Add(lineIndex, 0, "#subtitle couldn't be converted via ShiftJIS")
 				}
 				fileData.Metadata.Subtitle = b
 			} else if strings.HasPrefix(lineLower, "#subartist") {
 				if len(line) < 12 {
-					if conf.Verbose {
-						color.HiYellow("* #subartist is invalid, ignoring (Line: %d)", lineIndex)
-					}
+					fileData.Warnings.Add(lineIndex, 0, "#subartist is invalid, ignoring")
 					continue
 				}
 				lineBytes := []byte(line[11:])
 				b, e := BytesFromShiftJIS(lineBytes)
 				if e != nil {
-					if conf.Verbose {
-						color.HiYellow("* #subartist couldn't be converted via ShiftJIS (Line: %d)", lineIndex)
-					}
+					fileData.Warnings.Add(lineIndex, 0, "#subartist couldn't be converted via ShiftJIS")
 				}
 				fileData.Metadata.SubArtists = append(fileData.Metadata.SubArtists, b)
 			} else if strings.HasPrefix(lineLower, "#title") {
 				if len(line) < 8 {
-					if conf.Verbose {
-						color.HiYellow("* #title is invalid, ignoring (Line: %d)", lineIndex)
-					}
+					fileData.Warnings.Add(lineIndex, 0, "#title is invalid, ignoring")
 					continue
 				}
 				lineBytes := []byte(line[7:])
 				b, e := BytesFromShiftJIS(lineBytes)
 				if e != nil {
-					if conf.Verbose {
-						color.HiYellow("* #title couldn't be converted via ShiftJIS (Line: %d)", lineIndex)
-					}
+					fileData.Warnings.Add(lineIndex, 0, "#title couldn't be converted via ShiftJIS")
 				}
 				fileData.Metadata.Title = b
+			} else if strings.HasPrefix(lineLower, "#maker") {
+				if len(line) < 8 {
+					fileData.Warnings.Add(lineIndex, 0, "#maker is invalid, ignoring")
+					continue
+				}
+				b, e := BytesFromShiftJIS([]byte(line[7:]))
+				if e != nil {
+					fileData.Warnings.Add(lineIndex, 0, "#maker couldn't be converted via ShiftJIS")
+				}
+				fileData.Metadata.Maker = b
+			} else if strings.HasPrefix(lineLower, "#comment") {
+				if len(line) < 10 {
+					fileData.Warnings.Add(lineIndex, 0, "#comment is invalid, ignoring")
+					continue
+				}
+				b, e := BytesFromShiftJIS([]byte(line[9:]))
+				if e != nil {
+					fileData.Warnings.Add(lineIndex, 0, "#comment couldn't be converted via ShiftJIS")
+				}
+				b = strings.Trim(strings.TrimSpace(b), "\"")
+				// Some charts split their comment across multiple #COMMENT headers
+				if len(fileData.Metadata.Comment) > 0 {
+					b = fileData.Metadata.Comment + " " + b
+				}
+				fileData.Metadata.Comment = b
+			} else if strings.HasPrefix(lineLower, "#text") || strings.HasPrefix(lineLower, "#song") {
+				if len(line) < 9 {
+					fileData.Warnings.Add(lineIndex, 0, "%s is invalid, ignoring", lineLower[:5])
+					continue
+				}
+				b, e := BytesFromShiftJIS([]byte(line[8:]))
+				if e != nil {
+					fileData.Warnings.Add(lineIndex, 0, "%s couldn't be converted via ShiftJIS", lineLower[:5])
+				}
+				fileData.Indices.Text[lineLower[5:7]] = strings.Trim(strings.TrimSpace(b), "\"")
 			} else if strings.HasPrefix(lineLower, "#lnobj") {
 				if len(line) < 8 {
-					if conf.Verbose {
-						color.HiRed("* #lnobj is not a valid length (Line: %d)", lineIndex)
-					}
-					return nil, nil
+					return nil, diagnostics.New(diagnostics.ErrInvalidLNObj, lineIndex, 0, "#LNOBJ has no value")
 				}
 				if len(lineLower[7:]) != 2 {
-					if conf.Verbose {
-						color.HiRed("* #lnobj was specified, but not 2 bytes in length. (Line: %d)", lineIndex)
-					}
-					return nil, nil
+					return nil, diagnostics.New(diagnostics.ErrInvalidLNObj, lineIndex, 8, "#LNOBJ isn't 2 characters long")
 				}
 				fileData.LNObject = lineLower[7:]
 			} else if strings.HasPrefix(lineLower, "#artist") {
 				if len(line) < 9 {
-					if conf.Verbose {
-						color.HiYellow("* #artist is invalid, ignoring (Line: %d)", lineIndex)
-					}
+					fileData.Warnings.Add(lineIndex, 0, "#artist is invalid, ignoring")
 					continue
 				}
 				lineBytes := []byte(line[8:])
 				b, e := BytesFromShiftJIS(lineBytes)
 				if e != nil {
-					if conf.Verbose {
-						color.HiYellow("* #artist couldn't be converted via ShiftJIS (Line: %d)", lineIndex)
-					}
+					fileData.Warnings.Add(lineIndex, 0, "#artist couldn't be converted via ShiftJIS")
 				}
 				fileData.Metadata.Artist = b
 			} else if strings.HasPrefix(lineLower, "#playlevel") {
 				if len(line) < 12 {
-					if conf.Verbose {
-						color.HiYellow("* #playlevel is invalid, ignoring (Line: %d)", lineIndex)
-					}
+					fileData.Warnings.Add(lineIndex, 0, "#playlevel is invalid, ignoring")
 					continue
 				}
 				fileData.Metadata.Difficulty = line[11:]
 			} else if strings.HasPrefix(lineLower, "#stagefile") {
-				if len(line) < 12 {
-					if conf.Verbose {
-						color.HiYellow("* #stagefile is invalid, ignoring (Line: %d)", lineIndex)
-					}
+				if f := conf.resolveArtworkHeader(fileData, input, line, 11, "stagefile", lineIndex); len(f) > 0 {
+					fileData.Metadata.StageFile = f
+				}
+			} else if strings.HasPrefix(lineLower, "#banner") {
+				if f := conf.resolveArtworkHeader(fileData, input, line, 8, "banner", lineIndex); len(f) > 0 {
+					fileData.Metadata.Banner = f
+				}
+			} else if strings.HasPrefix(lineLower, "#backbmp") {
+				if f := conf.resolveArtworkHeader(fileData, input, line, 9, "backbmp", lineIndex); len(f) > 0 {
+					fileData.Metadata.BackBMP = f
+				}
+			} else if strings.HasPrefix(lineLower, "#preview") {
+				if len(line) < 10 {
+					fileData.Warnings.Add(lineIndex, 0, "#preview is invalid, ignoring")
 					continue
 				}
-				requested := line[11:]
-				chosen := requested
-
-				if !FileExists(path.Join(inputPath, requested)) {
-					base := strings.TrimSuffix(requested, filepath.Ext(requested))
-					for _, ext := range []string{".png", ".jpg", ".jpeg", ".bmp", ".gif"} {
-						alt := base + ext
-						if FileExists(path.Join(inputPath, alt)) {
-							chosen = alt
-							if conf.Verbose {
-								color.HiYellow("* using \"%s\" for #stagefile instead of \"%s\" (Line: %d)", alt, requested, lineIndex)
-							}
-							break
-						}
-					}
-					if chosen == requested {
-						color.HiYellow("* \"%s\" (#stagefile) wasn't found; ignoring (Line: %d)", requested, lineIndex)
-						continue
+				decodedName, err := BytesFromShiftJIS([]byte(line[9:]))
+				if err != nil {
+					fileData.Warnings.Add(lineIndex, 0, "#preview filename decoding failed")
+					continue
+				}
+				// Some charts use #PREVIEW for an image instead of audio.
+				if IsImageFile(decodedName) {
+					if f := conf.resolveArtworkHeader(fileData, input, line, 9, "preview", lineIndex); len(f) > 0 {
+						fileData.Metadata.PreviewImage = f
 					}
+					continue
 				}
-				fileData.Metadata.StageFile = chosen
-			} else if strings.HasPrefix(lineLower, "#banner") {
+				preview := SearchForSoundFile(input, decodedName)
+				if len(preview) == 0 {
+					fileData.Warnings.Add(lineIndex, 0, "\"%s\" (#preview) wasn't found or isn't .wav/.mp3/.ogg/.3gp. ignoring", decodedName)
+					fileData.Assets.AddMissing("#preview", decodedName, lineIndex)
+					continue
+				}
+				fileData.Metadata.Preview = preview
+			} else if strings.HasPrefix(lineLower, "#volwav") {
 				if len(line) < 9 {
-					if conf.Verbose {
-						color.HiYellow("* #banner is invalid, ignoring (Line: %d)", lineIndex)
-					}
+					fileData.Warnings.Add(lineIndex, 0, "#volwav is invalid, ignoring")
 					continue
 				}
-				exists := FileExists(path.Join(inputPath, line[8:]))
-				if !exists {
-					color.HiYellow("* \"%s\" (#banner) wasn't found; ignoring (Line: %d)", line[8:], lineIndex)
+				i, e := strconv.ParseFloat(strings.TrimSpace(line[8:]), 64)
+				if e != nil || i < 0.0 {
+					fileData.Warnings.Add(lineIndex, 0, "#volwav is not a valid number, ignoring")
+					continue
 				}
-
-				fileData.Metadata.Banner = line[11:]
+				fileData.VolWav = i
 			} else if strings.HasPrefix(lineLower, "#bpm ") {
 				if len(line) < 6 {
-					if conf.Verbose {
-						color.HiRed("* #bpm XX is invalid, parsing cannot continue (Line: %d)", lineIndex)
-					}
-					return nil, nil
+					return nil, diagnostics.New(diagnostics.ErrInvalidBPM, lineIndex, 0, "#BPM has no value")
 				}
 				i, e := strconv.ParseFloat(line[5:], 64)
 				if e != nil {
-					if conf.Verbose {
-						color.HiRed("* #bpm XX is not a number, parsing cannot continue (Line: %d)", lineIndex)
-					}
-					return nil, nil
+					return nil, diagnostics.New(diagnostics.ErrInvalidBPM, lineIndex, 6, "%q isn't a number", line[5:])
 				}
 				// Here the initial BPM is set. Also set the timing point.
 				fileData.StartingBPM = i
 			} else if strings.HasPrefix(lineLower, "#bpm") {
 				if len(line) < 8 {
-					color.HiYellow("* BPM change invalid. will be ignored (Line: %d)", lineIndex)
+					fileData.Warnings.Add(lineIndex, 0, "BPM change invalid. will be ignored")
 					continue
 				}
 				i, e := strconv.ParseFloat(line[7:], 64)
 				if e != nil {
-					color.HiYellow("* BPM change is not a number. will be ignored (Line: %d)", lineIndex)
+					fileData.Warnings.Add(lineIndex, 0, "BPM change is not a number. will be ignored")
 					continue
 				}
 				fileData.Indices.BPMChanges[lineLower[4:6]] = i
 			} else if strings.HasPrefix(lineLower, "#bmp") {
 				if len(line) < 8 {
-					color.HiYellow("* BMP invalid, ignoring (Line: %d)", lineIndex)
+					fileData.Warnings.Add(lineIndex, 0, "BMP invalid, ignoring")
 					continue
 				}
-				exists := FileExists(path.Join(inputPath, line[7:]))
+				exists := FileExistsIn(input, line[7:])
 				if !exists {
-					color.HiYellow("* \"%s\" wasn't found; ignoring (Line: %d)", line[7:], lineIndex)
+					fileData.Warnings.Add(lineIndex, 0, "\"%s\" wasn't found; ignoring", line[7:])
+					fileData.Assets.AddMissing(line[:6], line[7:], lineIndex)
 					continue
 				}
 				fileData.Indices.BGA[lineLower[4:6]] = line[7:]
+			} else if strings.HasPrefix(lineLower, "#argb") {
+				if len(line) < 9 {
+					fileData.Warnings.Add(lineIndex, 0, "ARGB invalid, ignoring")
+					continue
+				}
+				c, ok := ParseARGB(line[8:])
+				if !ok {
+					fileData.Warnings.Add(lineIndex, 0, "ARGB isn't formatted as a,r,g,b, ignoring")
+					continue
+				}
+				fileData.Indices.ARGB[lineLower[5:7]] = c
+			} else if strings.HasPrefix(lineLower, "#bga") {
+				if len(line) < 8 {
+					fileData.Warnings.Add(lineIndex, 0, "BGA invalid, ignoring")
+					continue
+				}
+				d, ok := ParseBGADefinition(line[7:])
+				if !ok {
+					fileData.Warnings.Add(lineIndex, 0, "BGA isn't formatted as bb x1 y1 x2 y2 dx dy, ignoring")
+					continue
+				}
+				fileData.Indices.BGADefinitions[lineLower[4:6]] = d
+			} else if strings.HasPrefix(lineLower, "#swbga") {
+				if len(line) < 10 {
+					fileData.Warnings.Add(lineIndex, 0, "SWBGA invalid, ignoring")
+					continue
+				}
+				sw, ok := ParseSwitchBGA(line[9:])
+				if !ok {
+					fileData.Warnings.Add(lineIndex, 0, "SWBGA isn't formatted as fr:time:line:loop:a,r,g,b pattern, ignoring")
+					continue
+				}
+				sw.Line = lineIndex
+				fileData.Indices.SwitchBGA[lineLower[6:8]] = sw
 			} else if strings.HasPrefix(lineLower, "#stop") {
 				if len(line) < 9 {
-					color.HiYellow("* STOP isn't correctly formatted, not going to use it (Line: %d)", lineIndex)
+					fileData.Warnings.Add(lineIndex, 0, "STOP isn't correctly formatted, not going to use it")
 					continue
 				}
 				i, e := strconv.ParseFloat(line[8:], 64)
 				if e != nil {
-					color.HiYellow("* STOP is not a valid number, not going to use it (Line: %d)", lineIndex)
+					fileData.Warnings.Add(lineIndex, 0, "STOP is not a valid number, not going to use it")
 					continue
 				}
 				if i < 0.0 {
-					color.HiYellow("* STOP is negative (< 0.0), not going to use it (Line: %d)", lineIndex)
+					fileData.Warnings.Add(lineIndex, 0, "STOP is negative (< 0.0), not going to use it")
 					continue
 				}
 				fileData.Indices.Stops[lineLower[5:7]] = i
 			} else if strings.HasPrefix(lineLower, "#wav") {
 				if len(line) < 8 {
-					color.HiYellow("* WAV command invalid, all notes/sfx associated with it won't be placed (Line: %d)", lineIndex)
+					fileData.Warnings.Add(lineIndex, 0, "WAV command invalid, all notes/sfx associated with it won't be placed")
 					continue
 				}
 
@@ -321,16 +355,15 @@ func (conf *ProgramConfig) CompileBMSToStruct(inputPath string, bmsFileName stri
 				rawNameBytes := []byte(line[7:])
 				decodedName, err := BytesFromShiftJIS(rawNameBytes)
 				if err != nil {
-					if conf.Verbose {
-						color.HiYellow("* #wav filename decoding failed (Line: %d)", lineIndex)
-					}
+					fileData.Warnings.Add(lineIndex, 0, "#wav filename decoding failed")
 					continue
 				}
 				// Now look for that decoded name with any supported extension
-				soundEffect := SearchForSoundFile(inputPath, decodedName) // NEW
+				soundEffect := SearchForSoundFile(input, decodedName) // NEW
 
 				if len(soundEffect) == 0 {
-					color.HiYellow("* (#WAV) \"%s\" wasn't found or isn't .wav/.mp3/.ogg/.3gp. ignoring (Line: %d)", decodedName, lineIndex)
+					fileData.Warnings.Add(lineIndex, 0, "(#WAV) \"%s\" wasn't found or isn't .wav/.mp3/.ogg/.3gp. ignoring", decodedName)
+					fileData.Assets.AddMissing(line[:6], decodedName, lineIndex)
 					continue
 				}
 				fileData.Audio.StringArray = append(fileData.Audio.StringArray, soundEffect)
@@ -341,24 +374,23 @@ func (conf *ProgramConfig) CompileBMSToStruct(inputPath string, bmsFileName stri
 
 		tInt, e := strconv.ParseInt(line[1:4], 10, 64)
 		if e != nil {
-			color.HiRed("* Failed to parse track #, cannot continue parsing (Line: %d, Content: %s)", lineIndex, line)
-			return nil, nil
+			return nil, diagnostics.New(diagnostics.ErrInvalidMeasure, lineIndex, 2, "measure number %q isn't a number", line[1:4])
 		}
 		channel := lineLower[4:6]
 
 		// TODO: remove this; we just ignore mines now lmao
 		//if len(mineRegex.FindString(channel)) > 0 {
-		//	color.HiYellow("* Cannot parse maps with mines/fakes due to often being coupled with per-column SV, which neither quaver or osu support (Line: %d)", lineIndex)
+		//	conf.Log.HiYellow("* Cannot parse maps with mines/fakes due to often being coupled with per-column SV, which neither quaver or osu support (Line: %d)", lineIndex)
 		//	return nil, nil
 		//}
 		thisLineData := Line{
 			Channel: channel,
+			Number:  lineIndex,
 		}
 		if len(line) > 7 {
 			thisLineData.Message = strings.ToLower(line[7:])
 		}
 		fileData.TrackLines[int(tInt)] = append(fileData.TrackLines[int(tInt)], thisLineData)
-		lineIndex++
 	}
 
 	// Subtitle wasn't found and user doesn't want to keep implicit subtitles intact. Try
@@ -375,5 +407,8 @@ func (conf *ProgramConfig) CompileBMSToStruct(inputPath string, bmsFileName stri
 		return nil, err
 	}
 
+	fileData.Metadata.Artwork = CollectArtwork(input, fileData.Metadata)
+	fileData.Metadata.Background = SelectBackground(fileData.Metadata.Artwork, conf.FileType)
+
 	return fileData, nil
 }
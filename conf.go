@@ -1,6 +1,12 @@
 package main
 
-import "flag"
+import (
+	"flag"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
 
 type fileType int
 
@@ -9,6 +15,15 @@ const (
 	Osu
 )
 
+type storyboardMode int
+
+const (
+	// PerDiffStoryboard writes the storyboard into every .osu file.
+	PerDiffStoryboard storyboardMode = iota
+	// SharedStoryboard writes one .osb file per folder when every chart has the same storyboard.
+	SharedStoryboard
+)
+
 type ProgramConfig struct {
 	Verbose           bool
 	Input             string
@@ -25,49 +40,109 @@ type ProgramConfig struct {
 	NoScratchLane     bool
 	JSONOnly          bool
 	NoZip             bool
+	ConvertImages     bool
+	MaxImageSize      int
+	StoryboardMode    storyboardMode
+	PruneAssets       bool
+	KeepFiles         []string
+	Jobs              int
+	Report            string
+	Strict            bool
+	Force             bool
+	Watch             bool
+	WatchInterval     time.Duration
+
+	// jobs runs folders and charts concurrently, with no more than Jobs at the same time. It is shared by every copy
+	// of the config.
+	jobs *JobPool
+
+	// Log is where messages are printed to. Each folder and chart being converted concurrently has its own.
+	Log *Logger
+
+	// options contains the value of every option after reading the config file, and explicit the options given as
+	// flags, so folder config files can be applied on top of them (see WithFolderConfig).
+	options  map[string]string
+	explicit map[string]bool
 	//SpecialAlignment  bool
 }
 
-func NewProgramConfig() *ProgramConfig {
-	i := flag.String("i", "-", "Input folder containing BMS FOLDERS, NOT zip files!")
-	o := flag.String("o", "-", "Which folder you want the files to be output to")
-	vol := flag.Int("vol", 100, "How loud the key sounds should be (0-100 is acceptable, 100 default)")
-	fileTypeWanted := flag.String("type", "quaver", "Which file type to use. (quaver | osu)")
-	hp := flag.Float64("hp", 8.5, "If file type is 'osu', the HP drain (0-10, 8.5 default)")
-	od := flag.Float64("od", 8.0, "If file type is 'osu', the overall difficulty (0-10, 8 default)")
-	verbose := flag.Bool("v", false, "If specified, all logs will be shown.")
-	keepSubtitles := flag.Bool("keep-subtitles", false, "If this is specified, all implicit subtitles will be removed from the title of the map.")
-	noScratch := flag.Bool("auto-scratch", false, " If this is specified, all notes in the scratch lane will be replaced with sound effects instead, and the scratch lane will not be used.")
-	noStoryboard := flag.Bool("no-storyboard", false, "If file type is 'osu', and this is specified, background animation elements will be ignored.")
-	noMeasureLines := flag.Bool("no-measure-lines", false, "If this is specified, timing points will NOT be added at the end of each track to create visible measure lines. (It's a cosmetic thing and doesn't affect gameplay)")
-	noTimingPoints := flag.Bool("no-timing-points", false, "If this is specified then BPM changes will not exist. Helpful for maps whose bpm changes don't load correctly (This is equivalent to no SV)")
-	jsonOutput := flag.Bool("json", false, " If this is specified, file data will be output to a json file, which is put into the output folder.")
-	jsonOnly := flag.Bool("json-only", false, "When specified, no zips will be created, only .json files.")
-	noZip := flag.Bool("no-zip", false, "Skip creating .qp, .osz archive; leave output as folder")
+// defineFlags defines every option of the program on the set, and returns a function which builds the config from
+// their values once they are parsed.
+func defineFlags(set *flag.FlagSet) func() *ProgramConfig {
+	i := set.String("i", "-", "Input folder containing BMS folders or .zip files, or a single chart or .zip file (.7z and .rar aren't supported)")
+	o := set.String("o", "-", "Which folder you want the files to be output to")
+	vol := set.Int("vol", 100, "How loud the key sounds should be (0-100 is acceptable, 100 default)")
+	fileTypeWanted := set.String("type", "quaver", "Which file type to use. (quaver | osu)")
+	hp := set.Float64("hp", 8.5, "If file type is 'osu', the HP drain (0-10, 8.5 default)")
+	od := set.Float64("od", 8.0, "If file type is 'osu', the overall difficulty (0-10, 8 default)")
+	verbose := set.Bool("v", false, "If specified, all logs will be shown.")
+	keepSubtitles := set.Bool("keep-subtitles", false, "If this is specified, all implicit subtitles will be removed from the title of the map.")
+	noScratch := set.Bool("auto-scratch", false, " If this is specified, all notes in the scratch lane will be replaced with sound effects instead, and the scratch lane will not be used.")
+	noStoryboard := set.Bool("no-storyboard", false, "If file type is 'osu', and this is specified, background animation elements will be ignored.")
+	noMeasureLines := set.Bool("no-measure-lines", false, "If this is specified, timing points will NOT be added at the end of each track to create visible measure lines. (It's a cosmetic thing and doesn't affect gameplay)")
+	noTimingPoints := set.Bool("no-timing-points", false, "If this is specified then BPM changes will not exist. Helpful for maps whose bpm changes don't load correctly (This is equivalent to no SV)")
+	jsonOutput := set.Bool("json", false, " If this is specified, file data will be output to a json file, which is put into the output folder.")
+	jsonOnly := set.Bool("json-only", false, "When specified, no zips will be created, only .json files.")
+	noZip := set.Bool("no-zip", false, "Skip creating .qp, .osz archive; leave output as folder")
+	convertImages := set.Bool("convert-images", false, "If this is specified, .bmp (and other formats osu!/Quaver can't load well) images are converted to .png in the output.")
+	storyboardModeWanted := set.String("storyboard-mode", "diff", "If file type is 'osu', where the storyboard is written. (diff | shared) 'shared' writes one .osb per folder when all charts have the same storyboard.")
+	maxImageSize := set.Int("max-image-size", 0, "If above 0, images wider or taller than this many pixels are downscaled in the output. (0 default, disabled)")
+	pruneAssets := set.Bool("prune-assets", false, "If this is specified, only files used by the converted charts are packaged. (samples, backgrounds, storyboard frames, videos)")
+	keepFiles := set.String("keep", "", "If -prune-assets is specified, a comma separated list of patterns (e.g. *.txt,readme*) of additional files to package.")
+	report := set.String("report", "", "If specified, a report of every chart (converted, skipped or failed, and why) is written to this file. (.json or .csv)")
+	strict := set.Bool("strict", false, "If this is specified, the program exits with an error if there were any warnings, such as missing files.")
+	force := set.Bool("force", false, "If this is specified, every folder is converted again, even if its output is up to date.")
+	watch := set.Bool("watch", false, "If this is specified, the input folder is watched, and song folders are converted whenever they are added or changed. Runs until stopped with Ctrl+C.")
+	watchInterval := set.Duration("watch-interval", 5*time.Second, "With -watch, how often the input folder is checked for changes. (5s default)")
+	jobs := set.Int("jobs", 1, "How many folders and charts are converted at the same time. (1 default)")
 
 	// TODO: Implement 5K+1 alignment feature someday
-	//specialAlignment := flag.String("5k-alignment", "right", "If the style is 5K+1, where should the notes be aligned to? (left for 1-5, right for 3-7. Default is right.)")
-	flag.Parse()
+	//specialAlignment := set.String("5k-alignment", "right", "If the style is 5K+1, where should the notes be aligned to? (left for 1-5, right for 3-7. Default is right.)")
 
-	fType := Quaver
-	if *fileTypeWanted == "osu" {
-		fType = Osu
-	}
-	return &ProgramConfig{
-		Input:             *i,
-		Output:            *o,
-		Volume:            ClampInt(*vol, 100, 0),
-		Verbose:           *verbose,
-		FileType:          fType,
-		HPDrain:           ClampFloat(*hp, 10.0, 0.0),
-		OverallDifficulty: ClampFloat(*od, 10.0, 0.0),
-		KeepSubtitles:     *keepSubtitles,
-		NoStoryboard:      *noStoryboard,
-		NoMeasureLines:    *noMeasureLines,
-		JSONOutput:        *jsonOutput,
-		NoTimingPoints:    *noTimingPoints,
-		NoScratchLane:     *noScratch,
-		JSONOnly:          *jsonOnly,
-		NoZip:             *noZip,
+	return func() *ProgramConfig {
+		fType := Quaver
+		if *fileTypeWanted == "osu" {
+			fType = Osu
+		}
+		var keep []string
+		for _, p := range strings.Split(*keepFiles, ",") {
+			if p = strings.TrimSpace(p); len(p) > 0 {
+				keep = append(keep, p)
+			}
+		}
+		sbMode := PerDiffStoryboard
+		if *storyboardModeWanted == "shared" {
+			sbMode = SharedStoryboard
+		}
+		return &ProgramConfig{
+			Input:             *i,
+			Output:            *o,
+			Volume:            ClampInt(*vol, 100, 0),
+			Verbose:           *verbose,
+			FileType:          fType,
+			HPDrain:           ClampFloat(*hp, 10.0, 0.0),
+			OverallDifficulty: ClampFloat(*od, 10.0, 0.0),
+			KeepSubtitles:     *keepSubtitles,
+			NoStoryboard:      *noStoryboard,
+			NoMeasureLines:    *noMeasureLines,
+			JSONOutput:        *jsonOutput,
+			NoTimingPoints:    *noTimingPoints,
+			NoScratchLane:     *noScratch,
+			JSONOnly:          *jsonOnly,
+			NoZip:             *noZip,
+			ConvertImages:     *convertImages,
+			MaxImageSize:      MaxInt(*maxImageSize, 0),
+			StoryboardMode:    sbMode,
+			PruneAssets:       *pruneAssets,
+			KeepFiles:         keep,
+			Jobs:              MaxInt(*jobs, 1),
+			jobs:              NewJobPool(*jobs),
+			Report:            *report,
+			Strict:            *strict,
+			Force:             *force,
+			Watch:             *watch,
+			WatchInterval:     time.Duration(MaxInt(int(*watchInterval), int(time.Second))),
+			Log:               NewLogger(color.Output),
+		}
 	}
 }
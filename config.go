@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FolderConfigFile is the name of the config file which can be put next to the charts of a song folder, to change
+// options for that folder only.
+const FolderConfigFile = "bmt.json"
+
+// Presets are named sets of options, which can be used with -preset or "preset" in a config file. Options given
+// alongside the preset override it.
+var Presets = map[string]map[string]string{
+	// Maps which follow Quaver's ranking criteria as closely as possible: no unused files and no huge images.
+	"quaver-ranked": {
+		"type":           "quaver",
+		"prune-assets":   "true",
+		"convert-images": "true",
+		"max-image-size": "1920",
+	},
+	// Maps for practicing: no HP drain, and no storyboard to distract from the notes.
+	"osu-practice": {
+		"type":          "osu",
+		"hp":            "0",
+		"od":            "5",
+		"no-storyboard": "true",
+	},
+	// Maps for sharing in osu!, with a single storyboard per mapset to keep it small.
+	"osu-storyboard": {
+		"type":            "osu",
+		"storyboard-mode": "shared",
+		"convert-images":  "true",
+	},
+}
+
+// globalOptions can't be changed by folder config files, since they apply to the whole conversion.
+var globalOptions = map[string]bool{
+	"i":              true,
+	"o":              true,
+	"jobs":           true,
+	"report":         true,
+	"strict":         true,
+	"force":          true,
+	"watch":          true,
+	"watch-interval": true,
+}
+
+// ParseProgramConfig defines every option on the set, and reads them from the arguments. Flags take precedence over
+// the config file (-config), which takes precedence over the preset (-preset), which takes precedence over the
+// defaults.
+func ParseProgramConfig(set *flag.FlagSet, args []string) (*ProgramConfig, error) {
+	// Only the options of the program are kept, not the ones of subcommands (such as -addr) or -config and -preset.
+	subcommandFlags := map[string]bool{}
+	set.VisitAll(func(f *flag.Flag) {
+		subcommandFlags[f.Name] = true
+	})
+	build := defineFlags(set)
+	optionNames := map[string]bool{}
+	set.VisitAll(func(f *flag.Flag) {
+		optionNames[f.Name] = !subcommandFlags[f.Name]
+	})
+	configFile := set.String("config", "", "A JSON file with options to use, named like the flags (e.g. {\"type\": \"osu\", \"preset\": \"osu-practice\"}). Flags override it.")
+	preset := set.String("preset", "", "A set of options to start from. ("+strings.Join(PresetNames(), " | ")+")")
+	if err := set.Parse(args); err != nil {
+		return nil, err
+	}
+
+	explicit := map[string]bool{}
+	set.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	values := map[string]string{}
+	if len(*configFile) > 0 {
+		// Only JSON is read, so other formats get a clearer error than a JSON syntax error.
+		switch strings.ToLower(filepath.Ext(*configFile)) {
+		case ".toml", ".yaml", ".yml":
+			return nil, fmt.Errorf("%s: config files have to be JSON", *configFile)
+		}
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		if values, err = ParseConfig(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", *configFile, err)
+		}
+	}
+	if explicit["preset"] {
+		values["preset"] = *preset
+	}
+	values, err := expandPreset(values)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyOptions(set, values, explicit); err != nil {
+		return nil, err
+	}
+
+	conf := build()
+	conf.options = map[string]string{}
+	conf.explicit = explicit
+	set.VisitAll(func(f *flag.Flag) {
+		if optionNames[f.Name] {
+			conf.options[f.Name] = f.Value.String()
+		}
+	})
+	return conf, nil
+}
+
+// WithFolderConfig returns a copy of the config with the options of a folder config file applied. Flags still take
+// precedence over them.
+func (conf *ProgramConfig) WithFolderConfig(values map[string]string) (*ProgramConfig, error) {
+	return conf.withOptions(values, conf.explicit)
+}
+
+// withOptions returns a copy of the config with the options applied, except for the ones in keep. Options in the
+// copy's config files can't override any of them.
+func (conf *ProgramConfig) withOptions(values map[string]string, keep map[string]bool) (*ProgramConfig, error) {
+	values, err := expandPreset(values)
+	if err != nil {
+		return nil, err
+	}
+	for name := range values {
+		if globalOptions[name] {
+			return nil, fmt.Errorf("-%s can only be given on the command line", name)
+		}
+	}
+	set := flag.NewFlagSet(FolderConfigFile, flag.ContinueOnError)
+	build := defineFlags(set)
+	if err := applyOptions(set, conf.options, nil); err != nil {
+		return nil, err
+	}
+	if err := applyOptions(set, values, keep); err != nil {
+		return nil, err
+	}
+	explicit := map[string]bool{}
+	for name := range conf.explicit {
+		explicit[name] = true
+	}
+	for name := range values {
+		explicit[name] = true
+	}
+
+	folderConf := build()
+	options := map[string]string{}
+	set.VisitAll(func(f *flag.Flag) {
+		options[f.Name] = f.Value.String()
+	})
+	folderConf.Input, folderConf.Output = conf.Input, conf.Output
+	folderConf.Jobs, folderConf.Report, folderConf.Strict, folderConf.Force = conf.Jobs, conf.Report, conf.Strict, conf.Force
+	folderConf.Log, folderConf.jobs = conf.Log, conf.jobs
+	folderConf.options, folderConf.explicit = options, explicit
+	return folderConf, nil
+}
+
+// ReadFolderConfig returns the options of the folder config file in the input, or nil if there isn't one.
+func ReadFolderConfig(input fs.FS) (map[string]string, error) {
+	data, err := fs.ReadFile(input, FolderConfigFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data)
+}
+
+// ParseConfig reads the options of a JSON config file, whose keys are the names of flags. Lists (for -keep) are
+// joined by commas.
+func ParseConfig(data []byte) (map[string]string, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for name, v := range raw {
+		switch v := v.(type) {
+		case string:
+			values[name] = v
+		case bool:
+			values[name] = strconv.FormatBool(v)
+		case float64:
+			values[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		case []interface{}:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("%s can only contain strings", name)
+				}
+				items = append(items, s)
+			}
+			values[name] = strings.Join(items, ",")
+		default:
+			return nil, fmt.Errorf("%s has an invalid value", name)
+		}
+	}
+	return values, nil
+}
+
+// PresetNames returns the names of every preset, sorted.
+func PresetNames() []string {
+	names := make([]string, 0, len(Presets))
+	for name := range Presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// expandPreset replaces "preset" in the options with the options of the preset, unless they are already given.
+func expandPreset(values map[string]string) (map[string]string, error) {
+	name, ok := values["preset"]
+	if !ok {
+		return values, nil
+	}
+	preset, ok := Presets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown preset %q (%s)", name, strings.Join(PresetNames(), " | "))
+	}
+	expanded := map[string]string{}
+	for k, v := range preset {
+		expanded[k] = v
+	}
+	for k, v := range values {
+		if k != "preset" {
+			expanded[k] = v
+		}
+	}
+	return expanded, nil
+}
+
+// applyOptions sets the flags of the set to the options, skipping the ones in skip.
+func applyOptions(set *flag.FlagSet, values map[string]string, skip map[string]bool) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if skip[name] {
+			continue
+		}
+		if set.Lookup(name) == nil {
+			return fmt.Errorf("unknown option %q", name)
+		}
+		if err := set.Set(name, values[name]); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %w", values[name], name, err)
+		}
+	}
+	return nil
+}
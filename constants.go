@@ -11,10 +11,6 @@ const (
 	// http://bm98.yaneu.com/bm98/bmsformat.html
 	DefaultStartingBPM = 130.0
 
-	// TempDir is the directory name used by BMT to temporarily store files.
-	// It is removed when the program exits, regardless of status.
-	TempDir = "bmt_temp_folder"
-
 	// Version is the current version of the program.
 	Version = "0.2.4"
 
@@ -22,6 +18,10 @@ const (
 	// Also included in JSON files as a "version" key.
 	JSONVersion = "v1"
 
+	// PreviewWindow is the length, in milliseconds, of the window used to find the densest part of a chart
+	// when #PREVIEW can't be used to determine the song preview time.
+	PreviewWindow = 15.0 * Second
+
 	// Base36Range is used for lane conversion.
 	Base36Range = "0123456789abcdefghijklmnopqrstuvwxyz"
 )
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// ConvertFolder converts every chart in a song folder, and packages them.
+func (conf *ProgramConfig) ConvertFolder(song SongFolder, folderIndex int, folderCount int) ConversionStatus {
+	conf.Log.White("* [%d/%d] Processing %s", folderIndex+1, folderCount, color.YellowString(song.Relative))
+	status := ConversionStatus{Name: song.Relative}
+	name := song.Name
+	input, closeInput, err := OpenSongFolder(song)
+	if err != nil {
+		status.Skip = true
+		conf.Log.HiRed("* Failed to read %s. Skipping. (Error: %s)", song.Relative, err.Error())
+		status.Charts = []ChartReport{{Folder: song.Relative, Warnings: []string{}}}
+		status.Charts[0].fail(err, ReasonFolderUnreadable)
+		return status
+	}
+	defer func() {
+		if err := closeInput(); err != nil {
+			conf.Log.HiYellow("* Failed to close %s: %s", song.Relative, err.Error())
+		}
+	}()
+	bmsChartFiles := song.Charts
+	status.Charts = make([]ChartReport, len(bmsChartFiles))
+	for i, bmsFile := range bmsChartFiles {
+		status.Charts[i] = ChartReport{Folder: song.Relative, Chart: bmsFile, Status: ChartConverted, Warnings: []string{}}
+	}
+
+	// Options can be changed for this folder only, with a config file next to its charts.
+	folderOptions, err := ReadFolderConfig(input)
+	if err == nil && folderOptions != nil {
+		var folderConf *ProgramConfig
+		if folderConf, err = conf.WithFolderConfig(folderOptions); err == nil {
+			conf = folderConf
+			if conf.Verbose {
+				conf.Log.HiBlack("* Using options from %s", FolderConfigFile)
+			}
+		}
+	}
+	if err != nil {
+		status.Skip = true
+		conf.Log.HiRed("* %s of %s is invalid. Skipping. (Error: %s)", FolderConfigFile, song.Relative, err.Error())
+		for i := range status.Charts {
+			status.Charts[i].fail(err, ReasonFolderConfigInvalid)
+		}
+		return status
+	}
+
+	// Only -no-zip writes a folder to the output directory; otherwise files are kept in memory until zipped.
+	output := filepath.ToSlash(path.Join(filepath.FromSlash(conf.Output), name))
+	var archive ArchiveWriter = NewMemoryArchive()
+	if conf.NoZip {
+		archive = FolderArchive{Path: output}
+	}
+
+	if conf.NoZip && !conf.JSONOnly {
+		err = os.Mkdir(output, 0755)
+		if err != nil {
+			conf.Log.HiRed("* Failed to create a folder for %s. Skipping. (%s)", song.Relative, err.Error())
+			for i := range status.Charts {
+				status.Charts[i].fail(err, ReasonWriteFailed)
+			}
+			return status
+		}
+	}
+	// Copy all contents
+	//if conf.Verbose {
+	//	conf.Log.HiBlack("* Checks passed; copying %s to %s.", input, output)
+	//}
+	//err = copy2.Copy(input, output)
+	//if err != nil {
+	//	status.Skip = true
+	//	conf.Log.HiRed("* Failed to copy %s. Skipping. (%s)", song.Relative, err.Error())
+	//	continue
+	//}
+
+	if conf.Verbose {
+		conf.Log.HiBlack("* Found %d charts to process", len(bmsChartFiles))
+	}
+
+	zipExtension := "qp"
+	fileExtension := "qua"
+	switch conf.FileType {
+	case Osu:
+		zipExtension = "osz"
+		fileExtension = "osu"
+	}
+	// Images cropped by #BGA definitions of all charts, generated while packaging.
+	var croppedImages []CroppedImage
+	// Animations detected in all charts, whose frames are copied while packaging.
+	var animations []BGAAnimation
+	// Background videos of all charts, which are relabeled while packaging if their extension is wrong.
+	var videos []BackgroundVideo
+	// Files referenced by any chart, to find files in the folder that nothing uses.
+	var referencedFiles []string
+	// Files used by any converted chart, which are the only ones packaged with -prune-assets.
+	var usedFiles []string
+	// Charts are all parsed before writing them, so they can share a storyboard.
+	var parsedChartFiles []string
+	var parsedCharts []BMSFileData
+	var parsedReports []*ChartReport
+	// Parsing is the slowest part, so charts are parsed concurrently. Their results (and logs) are still handled in
+	// order, so the output is the same regardless of -jobs.
+	type parsedChart struct {
+		fileData *BMSFileData
+		err      error
+		log      *bytes.Buffer
+	}
+	results := make([]parsedChart, len(bmsChartFiles))
+	conf.jobs.Run(len(bmsChartFiles), func(diffIndex int) {
+		chartConf, log := conf.withBufferedLog()
+		bmsFile := bmsChartFiles[diffIndex]
+		if conf.Verbose {
+			if conf.JSONOnly {
+				chartConf.Log.HiBlack("* [%d/%d] %s -> .json", diffIndex+1, len(bmsChartFiles), bmsFile)
+			} else {
+				chartConf.Log.HiBlack("* [%d/%d] %s -> .%s ", diffIndex+1, len(bmsChartFiles), bmsFile, fileExtension)
+			}
+		}
+		fileData, err := chartConf.ReadFileData(input, bmsFile)
+		results[diffIndex] = parsedChart{fileData: fileData, err: err, log: log}
+	})
+	for diffIndex, bmsFile := range bmsChartFiles {
+		fileData, err := results[diffIndex].fileData, results[diffIndex].err
+		_, _ = results[diffIndex].log.WriteTo(conf.Log)
+		report := &status.Charts[diffIndex]
+		if err != nil {
+			status.Fail++
+			report.fail(err, ReasonReadFailed)
+			if report.Status == ChartSkipped {
+				conf.Log.HiYellow("* %s was skipped: %s", bmsFile, err.Error())
+			} else {
+				conf.Log.HiRed("* %s wasn't parsed due to an error: %s", bmsFile, err.Error())
+			}
+			continue
+		}
+
+		if conf.FileType == Osu && conf.Verbose {
+			conf.Log.HiBlack("* osu! specific: found %d background animation frames", len(fileData.BGAFrames))
+		}
+		status.MissingAssets += len(fileData.Assets.Missing)
+		status.UnusedDefinitions += len(fileData.Assets.Unused)
+		report.Warnings = fileData.Warnings.Strings()
+		status.Warnings += len(report.Warnings)
+		if conf.Verbose {
+			for _, w := range report.Warnings {
+				conf.Log.HiYellow("* %s: %s", bmsFile, w)
+			}
+			for _, m := range fileData.Assets.Missing {
+				conf.Log.HiBlack("* %s: missing %s (%s, Line: %d)", bmsFile, m.File, m.Header, m.Line)
+			}
+			for _, u := range fileData.Assets.Unused {
+				conf.Log.HiBlack("* %s: %s (%s) is never used", bmsFile, u.Header, u.File)
+			}
+		} else if len(report.Warnings) > 0 {
+			conf.Log.HiYellow("* %s: %d warnings (use -v to show them)", bmsFile, len(report.Warnings))
+		}
+		referencedFiles = append(referencedFiles, GetReferencedFiles(*fileData)...)
+		croppedImages = append(croppedImages, fileData.CroppedImages...)
+		animations = append(animations, GetAnimations(fileData.BGAFrames)...)
+		if fileData.Video != nil {
+			videos = append(videos, *fileData.Video)
+		}
+
+		if conf.JSONOutput || conf.JSONOnly {
+			bmsFileName := strings.TrimSuffix(bmsFile, path.Ext(bmsFile))
+			jsonPath := path.Join(conf.Output, name+" - "+bmsFileName+".json")
+			err = conf.ConvertBmsToJson(*fileData, jsonPath)
+			if err != nil && conf.Verbose {
+				conf.Log.HiRed("* failed to write json for %s: %s", bmsFile, err.Error())
+			}
+			if err != nil && conf.JSONOnly {
+				report.fail(err, ReasonWriteFailed)
+			} else if err == nil {
+				report.Output = jsonPath
+				status.Outputs = append(status.Outputs, jsonPath)
+			}
+		}
+		if conf.JSONOnly {
+			status.Success++
+			continue
+		}
+		usedFiles = append(usedFiles, conf.GetUsedFiles(*fileData)...)
+		parsedChartFiles = append(parsedChartFiles, bmsFile)
+		parsedCharts = append(parsedCharts, *fileData)
+		parsedReports = append(parsedReports, report)
+	}
+
+	unreferenced, err := FindUnreferencedFiles(input, append(append([]string{FolderConfigFile}, bmsChartFiles...), referencedFiles...))
+	if err != nil {
+		status.Warnings++
+		conf.Log.HiYellow("* Failed to look for unreferenced files in %s: %s", song.Relative, err.Error())
+	}
+	status.UnreferencedFiles = unreferenced
+	if conf.Verbose {
+		for _, u := range unreferenced {
+			conf.Log.HiBlack("* %s isn't referenced by any chart", u)
+		}
+	}
+
+	sharedStoryboard := false
+	if conf.FileType == Osu && conf.StoryboardMode == SharedStoryboard && !conf.NoStoryboard {
+		sharedStoryboard, err = conf.ConvertStoryboardToOsb(parsedCharts, archive)
+		if err != nil {
+			status.Warnings++
+			conf.Log.HiYellow("* Failed to write a shared storyboard for %s: %s", song.Relative, err.Error())
+		}
+		if !sharedStoryboard && conf.Verbose {
+			conf.Log.HiBlack("* Storyboards differ between charts; each chart will have its own")
+		}
+	}
+
+	for i, bmsFile := range parsedChartFiles {
+		writeTo := strings.TrimSuffix(bmsFile, path.Ext(bmsFile)) + "." + fileExtension
+		switch conf.FileType {
+		case Osu:
+			err = conf.ConvertBmsToOsu(parsedCharts[i], archive, writeTo, !sharedStoryboard)
+			break
+		default:
+			err = conf.ConvertBmsToQua(parsedCharts[i], archive, writeTo)
+		}
+		if err != nil {
+			status.Fail++
+			conf.Log.HiYellow("* %s wasn't written to due to an error: %s", bmsFile, err.Error())
+			parsedReports[i].fail(err, ReasonWriteFailed)
+			continue
+		}
+		parsedReports[i].Output = path.Join(output, writeTo)
+		if !conf.NoZip {
+			parsedReports[i].Output = path.Join(conf.Output, name+"."+zipExtension, writeTo)
+		}
+
+		status.Success++
+	}
+
+	if !conf.JSONOnly && len(croppedImages) > 0 {
+		if err := GenerateCroppedImages(input, archive, croppedImages); err != nil {
+			status.Warnings++
+			conf.Log.HiYellow("* Failed to crop #BGA images of %s: %s", song.Relative, err.Error())
+		}
+	}
+
+	// Transcode images between conversion and packaging, so the charts can be pointed at the new files.
+	replacedAssets := map[string]string{}
+	if !conf.JSONOnly && (conf.ConvertImages || conf.MaxImageSize > 0) {
+		replacedAssets, err = conf.TranscodeImages(input, archive)
+		if err != nil {
+			status.Warnings++
+			conf.Log.HiYellow("* Failed to transcode images of %s: %s", song.Relative, err.Error())
+		}
+	}
+	if !conf.JSONOnly && len(animations) > 0 {
+		if err := GenerateAnimationFrames(input, archive, animations, replacedAssets); err != nil {
+			status.Warnings++
+			conf.Log.HiYellow("* Failed to copy animation frames of %s: %s", song.Relative, err.Error())
+		}
+	}
+	if !conf.JSONOnly && len(videos) > 0 {
+		if err := RelabelVideos(input, archive, videos, replacedAssets); err != nil {
+			status.Warnings++
+			conf.Log.HiYellow("* Failed to relabel videos of %s: %s", song.Relative, err.Error())
+		}
+	}
+	if err := RewriteAssetReferences(archive, replacedAssets); err != nil {
+		status.Warnings++
+		conf.Log.HiYellow("* Failed to rewrite image references of %s: %s", song.Relative, err.Error())
+	}
+
+	// Files from the input folder which are left out of the output.
+	excludedFiles := map[string]string{FolderConfigFile: ""}
+	for k, v := range replacedAssets {
+		excludedFiles[k] = v
+	}
+	if !conf.JSONOnly && conf.PruneAssets {
+		pruned, err := FindUnreferencedFiles(input, usedFiles)
+		if err != nil {
+			status.Warnings++
+			conf.Log.HiYellow("* Failed to look for unused files in %s: %s", song.Relative, err.Error())
+		}
+		for _, p := range pruned {
+			if MatchesAnyPattern(p, conf.KeepFiles) {
+				continue
+			}
+			excludedFiles[p] = ""
+			if conf.Verbose {
+				conf.Log.HiBlack("* Pruning %s", p)
+			}
+		}
+	}
+
+	if !conf.JSONOnly && !conf.NoZip {
+		zipPath := path.Join(conf.Output, name+"."+zipExtension)
+		hash, err := WriteZip(archive, input, zipPath, excludedFiles)
+		if err != nil {
+			conf.Log.HiRed("* Failed to create %s: %s", path.Base(zipPath), err.Error())
+			status.Fail += status.Success
+			status.Success = 0
+			for _, report := range parsedReports {
+				if report.Status == ChartConverted {
+					report.fail(err, ReasonPackageFailed)
+					report.Output = ""
+				}
+			}
+		} else {
+			conf.Log.White("* %s (sha256: %s)", path.Base(zipPath), hash)
+			status.Outputs = append(status.Outputs, zipPath)
+		}
+	} else if conf.NoZip {
+		conf.Log.HiGreen("`-no-zip` Specified: Conversion output left uncompressed in %q", output)
+		status.Outputs = append(status.Outputs, output)
+		if err := CopyPath(input, output, excludedFiles); err != nil {
+			status.Warnings++
+			conf.Log.HiYellow("* Failed to copy source files from %s to %q: %s", song.Relative, output, err.Error())
+		}
+	}
+
+	if conf.Verbose {
+		conf.Log.HiBlack("* ---- Done with this folder ----")
+	}
+	return status
+}
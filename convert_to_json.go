@@ -7,13 +7,20 @@ import (
 )
 
 type JSONFileData struct {
-	ProgramVersion string        `json:"program_version"`
-	Version        string        `json:"version"`
-	Metadata       BMSMetadata   `json:"metadata"`
-	HitObjects     [][]HitObject `json:"hit_objects"`
-	TimingPoints   []TimingPoint `json:"timing_points"`
-	SampleIndex    []string      `json:"sample_index"`
-	SoundEffects   []SoundEffect `json:"sound_effects"`
+	ProgramVersion string           `json:"program_version"`
+	Version        string           `json:"version"`
+	Metadata       BMSMetadata      `json:"metadata"`
+	HitObjects     [][]HitObject    `json:"hit_objects"`
+	TimingPoints   []TimingPoint    `json:"timing_points"`
+	SampleIndex    []string         `json:"sample_index"`
+	SoundEffects   []SoundEffect    `json:"sound_effects"`
+	PreviewTime    float64          `json:"preview_time"`
+	VolWav         float64          `json:"volwav"`
+	VolumeChanges  []VolumeChange   `json:"volume_changes"`
+	TextEvents     []TextEvent      `json:"text_events"`
+	Video          *BackgroundVideo `json:"video"`
+	DroppedVideos  []string         `json:"dropped_videos"`
+	Assets         AssetReport      `json:"assets"`
 }
 
 type TimingPoint struct {
@@ -36,6 +43,13 @@ func (conf *ProgramConfig) ConvertBmsToJson(fileData BMSFileData, outputPath str
 		TimingPoints:   make([]TimingPoint, 0),
 		SampleIndex:    make([]string, 0),
 		SoundEffects:   make([]SoundEffect, 0),
+		PreviewTime:    fileData.PreviewTime,
+		VolWav:         fileData.VolWav,
+		VolumeChanges:  make([]VolumeChange, 0),
+		TextEvents:     make([]TextEvent, 0),
+		Video:          fileData.Video,
+		DroppedVideos:  make([]string, 0),
+		Assets:         fileData.Assets,
 		Version:        JSONVersion,
 		ProgramVersion: Version,
 	}
@@ -57,6 +71,15 @@ func (conf *ProgramConfig) ConvertBmsToJson(fileData BMSFileData, outputPath str
 	for _, s := range fileData.SoundEffects {
 		d.SoundEffects = append(d.SoundEffects, s)
 	}
+	d.VolumeChanges = append(d.VolumeChanges, fileData.VolumeChanges...)
+	d.TextEvents = append(d.TextEvents, fileData.TextEvents...)
+	d.DroppedVideos = append(d.DroppedVideos, fileData.DroppedVideos...)
+	if d.Assets.Missing == nil {
+		d.Assets.Missing = make([]MissingAsset, 0)
+	}
+	if d.Assets.Unused == nil {
+		d.Assets.Unused = make([]UnusedDefinition, 0)
+	}
 
 	// Avoid null conflict
 	//for i, h := range d.HitObjects {
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+var osbFileNameReplacer = strings.NewReplacer("\\", "", "/", "", ":", "", "*", "", "?", "", "\"", "", "<", "", ">", "", "|", "")
+
+// GetOsbFileName returns the name osu! looks for when loading a storyboard shared by all difficulties of a mapset.
+func GetOsbFileName(metadata BMSMetadata) string {
+	name := strings.TrimSpace(metadata.Artist) + " - " + strings.TrimSpace(metadata.Title) + " (" + strings.TrimSpace(GetCreator(metadata)) + ").osb"
+	return osbFileNameReplacer.Replace(name)
+}
+
+// ConvertStoryboardToOsb writes the storyboard of the charts to a single .osb file in the archive, if every chart has
+// the same storyboard and would look for the same .osb file. Storyboards are compared as written, so every generated
+// image (text sprites, cropped images and animations) has to be named after its content. Returns false if nothing was
+// written, in which case each .osu file needs to contain its own storyboard.
+func (conf *ProgramConfig) ConvertStoryboardToOsb(charts []BMSFileData, archive ArchiveWriter) (bool, error) {
+	if len(charts) == 0 {
+		return false, nil
+	}
+	var storyboard bytes.Buffer
+	name := GetOsbFileName(charts[0].Metadata)
+	for i, c := range charts {
+		if GetOsbFileName(c.Metadata) != name {
+			return false, nil
+		}
+		var b bytes.Buffer
+		conf.WriteStoryboardEvents(&b, c)
+		if i == 0 {
+			storyboard = b
+		} else if !bytes.Equal(storyboard.Bytes(), b.Bytes()) {
+			return false, nil
+		}
+	}
+	if storyboard.Len() == 0 {
+		return false, nil
+	}
+	// Every chart shows the same text, since its sprites are named after it.
+	if e := GenerateTextSprites(archive, charts[0].TextEvents); e != nil {
+		return false, e
+	}
+
+	osbFile, e := archive.Create(name)
+	if e != nil {
+		return false, e
+	}
+	defer osbFile.Abort()
+	if e = WriteLine(osbFile, "[Events]"); e != nil {
+		return false, e
+	}
+	if _, e = storyboard.WriteTo(osbFile); e != nil {
+		return false, e
+	}
+	return true, osbFile.Close()
+}
@@ -2,30 +2,38 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"math"
-	"os"
-	"path"
 	"sort"
+	"strconv"
 )
 
 const (
 	OsuYPos               = 192
 	OsuManiaPlayfieldSize = 512.0
+
+	// OsuBGAX and OsuBGAY are where the right center of the BGA area is placed in the storyboard.
+	OsuBGAX = 600
+	OsuBGAY = 240
 )
 
-// ConvertBmsToOsu converts a BMS file to .osu (for the game osu!).
-func (conf *ProgramConfig) ConvertBmsToOsu(fileData BMSFileData, outputPath string) error {
-	osuFile, e := os.Create(outputPath)
+// ConvertBmsToOsu converts a BMS file to .osu (for the game osu!), and writes it to the archive at name. If
+// withStoryboard is false, the storyboard is left out because it was written to a separate .osb file
+// (see ConvertStoryboardToOsb).
+func (conf *ProgramConfig) ConvertBmsToOsu(fileData BMSFileData, archive ArchiveWriter, name string, withStoryboard bool) error {
+	f, e := archive.Create(name)
 	if e != nil {
 		return e
 	}
-	defer osuFile.Close()
+	defer f.Abort()
+	osuFile := &errorWriter{w: f}
 
 	// flush contents to osu
 	_ = WriteLine(osuFile, "osu file format v14\n")
 
 	_ = WriteLine(osuFile, "[General]")
 	_ = WriteLine(osuFile, "Mode: 3")
+	_ = WriteLine(osuFile, fmt.Sprintf("PreviewTime: %d", int(fileData.PreviewTime)))
 	_ = WriteLine(osuFile, "SampleSet: Soft")
 	if !conf.NoScratchLane {
 		_ = WriteLine(osuFile, "SpecialStyle: 1")
@@ -43,7 +51,7 @@ func (conf *ProgramConfig) ConvertBmsToOsu(fileData BMSFileData, outputPath stri
 	_ = WriteLine(osuFile, fmt.Sprintf("Artist:%s", fileData.Metadata.Artist))
 	_ = WriteLine(osuFile, fmt.Sprintf("TitleUnicode:%s", fileData.Metadata.Title))
 	_ = WriteLine(osuFile, fmt.Sprintf("ArtistUnicode:%s", fileData.Metadata.Artist))
-	_ = WriteLine(osuFile, fmt.Sprintf("Creator:%s", AppendSubArtistsToArtist(fileData.Metadata.Artist, fileData.Metadata.SubArtists)))
+	_ = WriteLine(osuFile, fmt.Sprintf("Creator:%s", GetCreator(fileData.Metadata)))
 	_ = WriteLine(osuFile, "Source:BMS")
 	_ = WriteLine(osuFile, fmt.Sprintf("Tags:%s", fileData.Metadata.Tags))
 	_ = WriteLine(osuFile, fmt.Sprintf("Version:%s", GetDifficultyName(fileData.Metadata.Difficulty, fileData.Metadata.Subtitle, conf.NoScratchLane)))
@@ -63,37 +71,24 @@ func (conf *ProgramConfig) ConvertBmsToOsu(fileData BMSFileData, outputPath stri
 	_ = WriteLine(osuFile, "SliderTickRate:1")
 
 	_ = WriteLine(osuFile, "[Events]")
-	bg := fileData.Metadata.StageFile
-	if len(fileData.Metadata.Banner) > 0 && len(fileData.Metadata.StageFile) == 0 {
-		bg = fileData.Metadata.Banner
-	}
-	if len(bg) != 0 {
-		_ = WriteLine(osuFile, fmt.Sprintf("0,0,\"%s\",0,0", bg))
+	if len(fileData.Metadata.Background) != 0 {
+		_ = WriteLine(osuFile, fmt.Sprintf("0,0,\"%s\",0,0", fileData.Metadata.Background))
 	}
 
 	if !conf.NoStoryboard {
-		for i, bga := range fileData.BGAFrames {
-			endTime := 0.0
-			if i+1 != len(fileData.BGAFrames) {
-				endTime = fileData.BGAFrames[i+1].StartTime
-			}
-			vExt := path.Ext(bga.File)
-			layer := "Background"
-			if bga.Layer == Front {
-				layer = "Foreground"
-			}
-			if !(vExt == ".wmv" || vExt == ".mpg" || vExt == ".avi" || vExt == ".mp4" || vExt == ".webm" || vExt == ".mkv") {
-				_ = WriteLine(osuFile, fmt.Sprintf("Sprite,%s,%s,\"%s\",%d,%d", layer, "CentreRight", bga.File, 600, 240))
-				// osu doesn't like decimals in starting/ending times
-				_ = WriteLine(osuFile, fmt.Sprintf("_F,0,%d,%d,%d", int(bga.StartTime), int(endTime), 1))
-			} else {
-				_ = WriteLine(osuFile, fmt.Sprintf("Video,%d,\"%s\"", int(bga.StartTime), bga.File))
+		if fileData.Video != nil {
+			_ = WriteLine(osuFile, fmt.Sprintf("Video,%d,\"%s\"", int(fileData.Video.StartTime), fileData.Video.File))
+		}
+		if withStoryboard {
+			if e = GenerateTextSprites(archive, fileData.TextEvents); e != nil {
+				return e
 			}
+			conf.WriteStoryboardEvents(osuFile, fileData)
 		}
 	}
 
 	for _, sfx := range fileData.SoundEffects {
-		_ = WriteLine(osuFile, fmt.Sprintf("Sample,%d,%d,\"%s\",%d", int(sfx.StartTime), 0, fileData.Audio.StringArray[sfx.Sample-1], conf.Volume))
+		_ = WriteLine(osuFile, fmt.Sprintf("Sample,%d,%d,\"%s\",%d", int(sfx.StartTime), 0, fileData.Audio.StringArray[sfx.Sample-1], sfx.Volume))
 	}
 
 	_ = WriteLine(osuFile, "[TimingPoints]")
@@ -132,11 +127,11 @@ func (conf *ProgramConfig) ConvertBmsToOsu(fileData BMSFileData, outputPath stri
 	laneSize := OsuManiaPlayfieldSize / laneCt
 
 	_ = WriteLine(osuFile, "[HitObjects]")
-	for lane, objects := range fileData.HitObjects {
+	for _, lane := range GetSortedLanes(fileData.HitObjects) {
 		if lane == 8 && conf.NoScratchLane {
 			continue
 		}
-		for _, obj := range objects {
+		for _, obj := range fileData.HitObjects[lane] {
 			objType := 1 << 0
 			if obj.IsLongNote {
 				objType = 1 << 7
@@ -157,7 +152,8 @@ func (conf *ProgramConfig) ConvertBmsToOsu(fileData BMSFileData, outputPath stri
 			vol := 1
 			if obj.KeySounds != nil {
 				hitSound = fileData.Audio.StringArray[obj.KeySounds.Sample-1]
-				vol = 100
+				// A volume of 0 makes osu! fall back to the timing point's volume
+				vol = ClampInt(obj.KeySounds.Volume, 100, 1)
 			}
 			if objType == 1<<7 && int(obj.EndTime) > int(obj.StartTime) {
 				_ = WriteLine(osuFile, fmt.Sprintf("%d,%d,%d,%d,%d,%d:0:0:0:%d:%s",
@@ -184,10 +180,97 @@ func (conf *ProgramConfig) ConvertBmsToOsu(fileData BMSFileData, outputPath stri
 		}
 	}
 
-	e = osuFile.Sync()
-	if e != nil {
-		return e
+	if osuFile.err != nil {
+		return osuFile.err
+	}
+	return f.Close()
+}
+
+// osuLayerOrder is the order layers are written in. osu! draws sprites in the same layer in the order they appear,
+// so Front2 and Switch are written after Front to be stacked above it.
+var osuLayerOrder = []Layer{Back, Poor, Front, Front2, Switch}
+
+// GetOsuLayerName returns the osu! storyboard layer for the BGA layer. If the chart has a poor layer, the base layer
+// is put on the Pass layer and the poor layer on the Fail layer, so osu! switches between them as the player misses.
+func GetOsuLayerName(layer Layer, hasPoorLayer bool) string {
+	switch layer {
+	case Front, Front2, Switch:
+		return "Foreground"
+	case Poor:
+		return "Fail"
+	}
+	if hasPoorLayer {
+		return "Pass"
+	}
+	return "Background"
+}
+
+// WriteStoryboardEvents writes every storyboard element of the chart (BGA frames and text events). The images of text
+// events have to be added to the archive separately (see GenerateTextSprites).
+func (conf *ProgramConfig) WriteStoryboardEvents(w io.Writer, fileData BMSFileData) {
+	writeStoryboard(w, fileData.BGAFrames, getChartEndTime(fileData))
+	writeTextEvents(w, fileData.TextEvents)
+}
+
+// writeStoryboard writes all image BGA frames as sprites, one layer after another. The last frame of each layer is
+// shown until the chart ends.
+func writeStoryboard(w io.Writer, frames []BGAFrame, chartEndTime float64) {
+	hasPoorLayer := hasLayer(frames, Poor)
+	for _, l := range osuLayerOrder {
+		layerFrames := make([]BGAFrame, 0)
+		for _, f := range frames {
+			if f.Layer == l && !IsVideoFile(f.File) {
+				layerFrames = append(layerFrames, f)
+			}
+		}
+		for i, bga := range layerFrames {
+			endTime := bga.EndTime
+			if i+1 != len(layerFrames) && (endTime == 0.0 || layerFrames[i+1].StartTime < endTime) {
+				endTime = layerFrames[i+1].StartTime
+			}
+			if i+1 == len(layerFrames) && endTime == 0.0 {
+				endTime = math.Max(chartEndTime, bga.StartTime)
+			}
+			// Animations have to last long enough to be played, even if the chart ends first.
+			if bga.Animation != nil && i+1 == len(layerFrames) {
+				endTime = math.Max(endTime, bga.StartTime+bga.Animation.FrameDelay*float64(len(bga.Animation.Frames)))
+			}
+			// Cropped frames are placed relative to the top left corner of the BGA area.
+			origin, x, y := "CentreRight", OsuBGAX, OsuBGAY
+			if bga.Offset != nil {
+				origin, x, y = "TopLeft", OsuBGAX-BGASize+bga.Offset.X, OsuBGAY-BGASize/2+bga.Offset.Y
+			}
+			if bga.Animation != nil {
+				_ = WriteLine(w, fmt.Sprintf("Animation,%s,%s,\"%s\",%d,%d,%d,%s,LoopOnce", GetOsuLayerName(l, hasPoorLayer), origin, bga.File, x, y, len(bga.Animation.Frames), strconv.FormatFloat(math.Round(bga.Animation.FrameDelay*1000.0)/1000.0, 'f', -1, 64)))
+			} else {
+				_ = WriteLine(w, fmt.Sprintf("Sprite,%s,%s,\"%s\",%d,%d", GetOsuLayerName(l, hasPoorLayer), origin, bga.File, x, y))
+			}
+			// osu doesn't like decimals in starting/ending times
+			opacity := bga.Opacity
+			if bga.Color != nil {
+				opacity *= float64(bga.Color.A) / 255.0
+			}
+			_ = WriteLine(w, fmt.Sprintf("_F,0,%d,%d,%s", int(bga.StartTime), int(endTime), strconv.FormatFloat(math.Round(opacity*1000.0)/1000.0, 'f', -1, 64)))
+			if bga.Color != nil && !(bga.Color.R == 255 && bga.Color.G == 255 && bga.Color.B == 255) {
+				_ = WriteLine(w, fmt.Sprintf("_C,0,%d,%d,%d,%d,%d", int(bga.StartTime), int(endTime), bga.Color.R, bga.Color.G, bga.Color.B))
+			}
+		}
 	}
+}
 
-	return nil
+// writeTextEvents writes every text event which can be rendered as a sprite.
+func writeTextEvents(w io.Writer, events []TextEvent) {
+	for i, t := range events {
+		// Text is rendered with a font that only has ASCII characters
+		if !CanRenderText(t.Text) {
+			continue
+		}
+		file := TextSpriteFile(t.Text)
+		endTime := t.StartTime + TextDisplayDuration
+		if i+1 != len(events) {
+			endTime = events[i+1].StartTime
+		}
+		_ = WriteLine(w, fmt.Sprintf("Sprite,Foreground,BottomCentre,\"%s\",%d,%d", file, 320, 470))
+		_ = WriteLine(w, fmt.Sprintf("_F,0,%d,%d,%d", int(t.StartTime), int(endTime), 1))
+	}
 }
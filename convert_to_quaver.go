@@ -2,28 +2,29 @@ package main
 
 import (
 	"fmt"
-	"os"
 	"sort"
 	"strconv"
+	"strings"
 )
 
-func (conf *ProgramConfig) ConvertBmsToQua(fileData BMSFileData, outputPath string) error {
-	quaFile, e := os.Create(outputPath)
+// ConvertBmsToQua converts a BMS file to .qua (for the game Quaver), and writes it to the archive at name.
+func (conf *ProgramConfig) ConvertBmsToQua(fileData BMSFileData, archive ArchiveWriter, name string) error {
+	f, e := archive.Create(name)
 	if e != nil {
 		return e
 	}
-	defer quaFile.Close()
+	defer f.Abort()
+	quaFile := &errorWriter{w: f}
 
 	// flush contents to qua
 	_ = WriteLine(quaFile, "AudioFile: virtual")
-	_ = WriteLine(quaFile, "SongPreviewTime: -1")
-	bg := fileData.Metadata.StageFile
-	// always prefer banner in the quaver client because of the way song previews are displayed
-	if len(fileData.Metadata.Banner) > 0 {
-		bg = fileData.Metadata.Banner
+	_ = WriteLine(quaFile, fmt.Sprintf("SongPreviewTime: %d", int(fileData.PreviewTime)))
+	if len(fileData.Metadata.Background) != 0 {
+		_ = WriteLine(quaFile, "BackgroundFile: "+fileData.Metadata.Background)
 	}
-	if len(bg) != 0 {
-		_ = WriteLine(quaFile, "BackgroundFile: "+bg)
+	// Quaver shows banners separately on song select
+	if len(fileData.Metadata.Banner) != 0 {
+		_ = WriteLine(quaFile, "BannerFile: "+fileData.Metadata.Banner)
 	}
 	_ = WriteLine(quaFile, "MapId: -1")
 	_ = WriteLine(quaFile, "MapSetId: -1")
@@ -37,20 +38,30 @@ func (conf *ProgramConfig) ConvertBmsToQua(fileData BMSFileData, outputPath stri
 	_ = WriteLine(quaFile, fmt.Sprintf("Artist: '%s'", fileData.Metadata.Artist))
 	_ = WriteLine(quaFile, "Source: BMS")
 	_ = WriteLine(quaFile, fmt.Sprintf("Tags: '%s'", fileData.Metadata.Tags))
-	_ = WriteLine(quaFile, fmt.Sprintf("Creator: '%s'", AppendSubArtistsToArtist(fileData.Metadata.Artist, fileData.Metadata.SubArtists)))
+	_ = WriteLine(quaFile, fmt.Sprintf("Creator: '%s'", GetCreator(fileData.Metadata)))
 	_ = WriteLine(quaFile, fmt.Sprintf("DifficultyName: '%s'", GetDifficultyName(fileData.Metadata.Difficulty, fileData.Metadata.Subtitle, conf.NoScratchLane)))
-	_ = WriteLine(quaFile, "Description: Converted from BMS")
+	if len(fileData.Metadata.Comment) > 0 {
+		_ = WriteLine(quaFile, fmt.Sprintf("Description: 'Converted from BMS - %s'", strings.ReplaceAll(fileData.Metadata.Comment, "'", "''")))
+	} else {
+		_ = WriteLine(quaFile, "Description: Converted from BMS")
+	}
 	_ = WriteLine(quaFile, "EditorLayers: []")
 	// Process Hit Sound Paths
+	// Only samples which are played are listed (and packaged with -prune-assets), so they are numbered again.
 	_ = WriteLine(quaFile, "CustomAudioSamples:")
-	for _, m := range fileData.Audio.StringArray {
-		_ = WriteLine(quaFile, "- Path: "+m)
+	sampleIndex := map[int]int{}
+	for i, sample := range GetUsedSamples(fileData) {
+		sampleIndex[sample] = i + 1
+		_ = WriteLine(quaFile, "- Path: "+fileData.Audio.StringArray[sample-1])
 	}
 	// Process Sound Effects
 	_ = WriteLine(quaFile, "SoundEffects:")
 	for _, s := range fileData.SoundEffects {
+		if sampleIndex[s.Sample] == 0 {
+			continue
+		}
 		_ = WriteLine(quaFile, "- StartTime: "+strconv.Itoa(int(s.StartTime)))
-		_ = WriteLine(quaFile, "  Sample: "+strconv.Itoa(s.Sample))
+		_ = WriteLine(quaFile, "  Sample: "+strconv.Itoa(sampleIndex[s.Sample]))
 		_ = WriteLine(quaFile, "  Volume: "+strconv.Itoa(s.Volume))
 	}
 	// Process Timing Points
@@ -72,28 +83,26 @@ func (conf *ProgramConfig) ConvertBmsToQua(fileData BMSFileData, outputPath stri
 	_ = WriteLine(quaFile, "SliderVelocities: []")
 	// Process Hit Objects
 	_ = WriteLine(quaFile, "HitObjects:")
-	for lane, objects := range fileData.HitObjects {
+	for _, lane := range GetSortedLanes(fileData.HitObjects) {
 		if lane == 8 && conf.NoScratchLane {
 			continue
 		}
-		for _, obj := range objects {
+		for _, obj := range fileData.HitObjects[lane] {
 			_ = WriteLine(quaFile, "- StartTime: "+strconv.Itoa(int(obj.StartTime)))
 			_ = WriteLine(quaFile, "  Lane: "+strconv.Itoa(lane))
 			if obj.IsLongNote && int(obj.EndTime) > int(obj.StartTime) {
 				_ = WriteLine(quaFile, "  EndTime: "+strconv.Itoa(int(obj.EndTime)))
 			}
-			if obj.KeySounds != nil {
+			if obj.KeySounds != nil && sampleIndex[obj.KeySounds.Sample] > 0 {
 				_ = WriteLine(quaFile, "  KeySounds:")
-				_ = WriteLine(quaFile, "  - Sample: "+strconv.Itoa(obj.KeySounds.Sample))
+				_ = WriteLine(quaFile, "  - Sample: "+strconv.Itoa(sampleIndex[obj.KeySounds.Sample]))
 				_ = WriteLine(quaFile, "    Volume: "+strconv.Itoa(obj.KeySounds.Volume))
 			}
 		}
 	}
 
-	e = quaFile.Sync()
-	if e != nil {
-		return e
+	if quaFile.err != nil {
+		return quaFile.err
 	}
-
-	return nil
+	return f.Close()
 }
@@ -0,0 +1,137 @@
+// Package diagnostics contains the errors which stop a chart from being converted, and the warnings collected while
+// reading one.
+package diagnostics
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Errors which stop a chart from being converted. Charts return them wrapped in a *ChartError, so check for them with
+// errors.Is.
+var (
+	ErrUnsupportedPlayerMode = errors.New("unsupported player mode")
+	ErrInvalidPlayer         = errors.New("invalid #PLAYER")
+	ErrInvalidLNObj          = errors.New("invalid #LNOBJ")
+	ErrInvalidBPM            = errors.New("invalid #BPM")
+	ErrInvalidMeasure        = errors.New("invalid measure")
+	ErrPlayer2Notes          = errors.New("notes on player 2's side")
+	ErrTooManyKeys           = errors.New("too many keys")
+	ErrEmptyChart            = errors.New("empty chart")
+)
+
+var reasons = []struct {
+	err    error
+	reason string
+}{
+	{ErrUnsupportedPlayerMode, "unsupported_player_mode"},
+	{ErrInvalidPlayer, "invalid_player"},
+	{ErrInvalidLNObj, "invalid_lnobj"},
+	{ErrInvalidBPM, "invalid_bpm"},
+	{ErrInvalidMeasure, "invalid_measure"},
+	{ErrPlayer2Notes, "player_2_notes"},
+	{ErrTooManyKeys, "too_many_keys"},
+	{ErrEmptyChart, "empty_chart"},
+}
+
+// Reason returns a short code for the error (e.g. "too_many_keys"), or an empty string if it isn't one of the errors
+// above.
+func Reason(err error) string {
+	for _, r := range reasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+	return ""
+}
+
+// Position is a place in a chart. Line and Column start at 1, and are 0 if unknown.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	switch {
+	case p.Line == 0:
+		return ""
+	case p.Column == 0:
+		return "Line: " + strconv.Itoa(p.Line)
+	default:
+		return "Line: " + strconv.Itoa(p.Line) + ", Column: " + strconv.Itoa(p.Column)
+	}
+}
+
+// ChartError is one of the errors above, along with where it happened and details about it.
+type ChartError struct {
+	Position
+	Err    error
+	Detail string
+}
+
+// New returns an error for the problem, with the details formatted like fmt.Sprintf.
+func New(err error, line int, column int, format string, args ...interface{}) *ChartError {
+	return &ChartError{Position: Position{Line: line, Column: column}, Err: err, Detail: fmt.Sprintf(format, args...)}
+}
+
+func (e *ChartError) Error() string {
+	message := e.Err.Error()
+	if len(e.Detail) > 0 {
+		message += ": " + e.Detail
+	}
+	if p := e.Position.String(); len(p) > 0 {
+		message += " (" + p + ")"
+	}
+	return message
+}
+
+func (e *ChartError) Unwrap() error {
+	return e.Err
+}
+
+// InFile sets the file of err if it's a *ChartError which doesn't have one yet, and returns it.
+func InFile(err error, file string) error {
+	var chartErr *ChartError
+	if errors.As(err, &chartErr) && len(chartErr.File) == 0 {
+		chartErr.File = file
+	}
+	return err
+}
+
+// Warning is a problem which doesn't stop a chart from being converted, such as an invalid header or a missing file.
+type Warning struct {
+	Position
+	Message string
+}
+
+func (w Warning) String() string {
+	if p := w.Position.String(); len(p) > 0 {
+		return w.Message + " (" + p + ")"
+	}
+	return w.Message
+}
+
+// Collector collects the warnings of a chart.
+type Collector struct {
+	File     string
+	Warnings []Warning
+}
+
+// Add adds a warning at the line and column, with the message formatted like fmt.Sprintf.
+func (c *Collector) Add(line int, column int, format string, args ...interface{}) {
+	c.Warnings = append(c.Warnings, Warning{
+		Position: Position{File: c.File, Line: line, Column: column},
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// Strings returns every warning as a string.
+func (c *Collector) Strings() []string {
+	s := make([]string, 0, len(c.Warnings))
+	for _, w := range c.Warnings {
+		s = append(s, w.String())
+	}
+	return s
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SongFolder is a folder which directly contains charts, found anywhere in the input directory (or in a .zip file
+// in it).
+type SongFolder struct {
+	// Name is what the output of the folder is called. It's the name of the folder, or of the .zip file if the charts
+	// are at its root. Names are unique among all song folders.
+	Name string
+
+	// Location is the folder, or .zip file, on disk.
+	Location string
+
+	// Path is the folder inside the .zip file which contains the charts, or "." for the root.
+	Path string
+
+	// Relative is where the song folder is, relative to the input directory (e.g. "pack/song" or "pack.zip/song").
+	Relative string
+
+	// Charts contains the file names of every chart in the folder.
+	Charts []string
+
+	// Nested contains every folder (or .zip file) inside this one which is a song folder of its own, relative to this
+	// one. They are hidden when the folder is opened, so they aren't packaged with it.
+	Nested []string
+}
+
+// IsChartFile returns true if the file is a BMS chart.
+func IsChartFile(file string) bool {
+	// include .BME, .Bme, .bMe, .bmE ...
+	lower := strings.ToLower(file)
+	return strings.HasSuffix(lower, ".bms") || strings.HasSuffix(lower, ".bml") || strings.HasSuffix(lower, ".bme")
+}
+
+// DiscoverSongFolders walks the whole input directory, including the contents of .zip files, and returns every folder
+// which directly contains charts, sorted by location. Packs of songs can be nested as deep as needed. The input can
+// also be a single chart or .zip file.
+func (conf *ProgramConfig) DiscoverSongFolders() ([]SongFolder, error) {
+	root := filepath.FromSlash(conf.Input)
+	songs := make([]SongFolder, 0)
+	if info, err := os.Stat(root); err == nil && !info.IsDir() {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return nil, err
+		}
+		dir, file := filepath.Split(abs)
+		dir = filepath.Clean(dir)
+		if IsZipFile(file) {
+			songs = conf.zipSongFolders(dir, file)
+		} else if IsChartFile(file) {
+			songs = append(songs, SongFolder{
+				Name:     filepath.Base(dir),
+				Location: dir,
+				Path:     ".",
+				Relative: path.Join(filepath.Base(dir), file),
+				Charts:   []string{file},
+			})
+		}
+		return sortSongFolders(songs), nil
+	}
+
+	charts, zips, err := findCharts(os.DirFS(root), true)
+	if err != nil {
+		return nil, err
+	}
+	for dir, c := range charts {
+		name, relative := path.Base(dir), dir
+		if dir == "." {
+			// Charts directly in the input directory
+			name, relative = filepath.Base(root), filepath.Base(root)
+		}
+		songs = append(songs, SongFolder{
+			Name:     name,
+			Location: filepath.Join(root, filepath.FromSlash(dir)),
+			Path:     ".",
+			Relative: relative,
+			Charts:   c,
+		})
+	}
+	for _, z := range zips {
+		songs = append(songs, conf.zipSongFolders(root, z)...)
+	}
+	return sortSongFolders(songs), nil
+}
+
+// sortSongFolders sorts the song folders by location, makes their names unique and finds the ones nested in others.
+func sortSongFolders(songs []SongFolder) []SongFolder {
+	sort.Slice(songs, func(i, j int) bool {
+		return songs[i].Relative < songs[j].Relative
+	})
+
+	// Songs in different packs can have the same folder name, which would overwrite each other's output. Songs that
+	// are nested the least keep their name.
+	byDepth := make([]*SongFolder, len(songs))
+	for i := range songs {
+		byDepth[i] = &songs[i]
+	}
+	sort.SliceStable(byDepth, func(i, j int) bool {
+		return strings.Count(byDepth[i].Relative, "/") < strings.Count(byDepth[j].Relative, "/")
+	})
+	taken := map[string]bool{}
+	for _, song := range byDepth {
+		name := song.Name
+		for n := 2; taken[strings.ToLower(name)]; n++ {
+			name = song.Name + " (" + strconv.Itoa(n) + ")"
+		}
+		taken[strings.ToLower(name)] = true
+		song.Name = name
+	}
+
+	// A folder can contain charts and other song folders, which are converted on their own.
+	for i := range songs {
+		root := songRoot(songs[i])
+		for _, other := range songs {
+			container := songRoot(other)
+			if other.Location != songs[i].Location && IsZipFile(other.Location) {
+				container = filepath.ToSlash(other.Location)
+			}
+			if strings.HasPrefix(container, root+"/") {
+				songs[i].Nested = append(songs[i].Nested, strings.TrimPrefix(container, root+"/"))
+			}
+		}
+	}
+	return songs
+}
+
+// songRoot returns where the charts of the song folder are, as a slash separated path (inside its .zip file, if any).
+func songRoot(song SongFolder) string {
+	return path.Join(filepath.ToSlash(song.Location), song.Path)
+}
+
+// zipSongFolders returns every folder in the .zip file (at z, relative to root) which directly contains charts.
+func (conf *ProgramConfig) zipSongFolders(root string, z string) []SongFolder {
+	songs := make([]SongFolder, 0)
+	input, closeInput, e := OpenInput(filepath.Join(root, filepath.FromSlash(z)))
+	if e != nil {
+		conf.Log.HiRed("* Failed to read %s. Skipping. (Error: %s)", z, e.Error())
+		return songs
+	}
+	zipCharts, _, e := findCharts(input, false)
+	_ = closeInput()
+	if e != nil {
+		conf.Log.HiRed("* Failed to read %s. Skipping. (Error: %s)", z, e.Error())
+		return songs
+	}
+	for dir, c := range zipCharts {
+		song := SongFolder{
+			Name:     path.Base(dir),
+			Location: filepath.Join(root, filepath.FromSlash(z)),
+			Path:     dir,
+			Relative: path.Join(z, dir),
+			Charts:   c,
+		}
+		if dir == "." {
+			song.Name = strings.TrimSuffix(path.Base(z), path.Ext(z))
+		}
+		songs = append(songs, song)
+	}
+	return songs
+}
+
+// findCharts returns the charts of every folder in the input which directly contains any, and (if withZips is true)
+// the location of every .zip file.
+func findCharts(input fs.FS, withZips bool) (map[string][]string, []string, error) {
+	charts := map[string][]string{}
+	zips := make([]string, 0)
+	err := fs.WalkDir(input, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if withZips && IsZipFile(name) {
+			zips = append(zips, name)
+			return nil
+		}
+		if !IsChartFile(name) {
+			return nil
+		}
+		if info, e := d.Info(); e != nil || info.Size() == 0 {
+			return nil
+		}
+		charts[path.Dir(name)] = append(charts[path.Dir(name)], path.Base(name))
+		return nil
+	})
+	return charts, zips, err
+}
+
+// OpenSongFolder opens the folder of the song, so its charts and assets can be read. Song folders nested in it are
+// hidden. The returned function has to be called once the folder isn't needed anymore.
+func OpenSongFolder(song SongFolder) (fs.FS, func() error, error) {
+	input, closeInput, err := OpenInput(song.Location)
+	if err != nil {
+		return nil, nil, err
+	}
+	if song.Path != "." {
+		if input, err = fs.Sub(input, song.Path); err != nil {
+			_ = closeInput()
+			return nil, nil, err
+		}
+	}
+	if len(song.Nested) > 0 {
+		input = HideFiles(input, song.Nested)
+	}
+	return input, closeInput, nil
+}
@@ -1,10 +1,11 @@
 module github.com/vysiondev/bmtranslator
 
-go 1.17
+go 1.18
 
 require (
 	github.com/fatih/color v1.10.0
-	golang.org/x/text v0.3.4
+	golang.org/x/image v0.18.0
+	golang.org/x/text v0.16.0
 )
 
 require (
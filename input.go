@@ -0,0 +1,95 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// IsZipFile returns true if the file is a .zip archive, which can be used as a BMS folder.
+func IsZipFile(file string) bool {
+	return strings.EqualFold(path.Ext(file), ".zip")
+}
+
+// OpenInput opens a folder, or a .zip archive, so the files in it can be read. The returned function has to be
+// called once the input isn't needed anymore.
+func OpenInput(location string) (fs.FS, func() error, error) {
+	var input fs.FS
+	closeInput := func() error { return nil }
+	if IsZipFile(location) {
+		r, e := zip.OpenReader(location)
+		if e != nil && !errors.Is(e, zip.ErrInsecurePath) {
+			return nil, nil, e
+		}
+		decodeZipFileNames(&r.Reader)
+		input = &r.Reader
+		closeInput = r.Close
+	} else {
+		input = os.DirFS(location)
+	}
+	return input, closeInput, nil
+}
+
+// decodeZipFileNames decodes the names of files in the archive which aren't UTF-8 from Shift-JIS, since most BMS
+// archives were made on Japanese Windows. Backslashes are also replaced, so files can be found through fs.FS.
+// This has to be done before any file of the archive is opened.
+func decodeZipFileNames(r *zip.Reader) {
+	for _, f := range r.File {
+		if f.NonUTF8 && !utf8.ValidString(f.Name) {
+			if decoded, e := BytesFromShiftJIS([]byte(f.Name)); e == nil {
+				f.Name = decoded
+			}
+		}
+		f.Name = strings.ReplaceAll(f.Name, "\\", "/")
+	}
+}
+
+// hiddenFS is a file system with some files and folders left out.
+type hiddenFS struct {
+	fs.FS
+	hidden map[string]bool
+}
+
+// HideFiles returns the input without the given files and folders (relative, slash separated), as if they didn't
+// exist.
+func HideFiles(input fs.FS, names []string) fs.FS {
+	hidden := map[string]bool{}
+	for _, name := range names {
+		hidden[path.Clean(name)] = true
+	}
+	return hiddenFS{FS: input, hidden: hidden}
+}
+
+func (h hiddenFS) isHidden(name string) bool {
+	for ; name != "." && name != "/"; name = path.Dir(name) {
+		if h.hidden[name] {
+			return true
+		}
+	}
+	return false
+}
+
+func (h hiddenFS) Open(name string) (fs.File, error) {
+	if h.isHidden(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return h.FS.Open(name)
+}
+
+func (h hiddenFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if h.isHidden(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	entries, err := fs.ReadDir(h.FS, name)
+	visible := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		if !h.isHidden(path.Join(name, e.Name())) {
+			visible = append(visible, e)
+		}
+	}
+	return visible, err
+}
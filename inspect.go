@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// runInspect runs the inspect subcommand, which prints the headers and statistics of each chart given, as parsed for
+// conversion.
+func runInspect(args []string) int {
+	conf, charts, ok := parseCommand("inspect", args)
+	if !ok {
+		return ExitFatal
+	}
+	// BGA frames and videos are only read for osu!, and folder config files can't change that.
+	osuConf, err := conf.withOptions(map[string]string{"type": "osu"}, nil)
+	if err != nil {
+		conf.Log.HiRed("* %s", err.Error())
+		return ExitFatal
+	}
+	conf = osuConf
+	exitCode := ExitOK
+	for i, chart := range charts {
+		if i > 0 {
+			fmt.Println()
+		}
+		fileData, err := conf.readChart(chart)
+		if err != nil {
+			conf.Log.HiRed("* %s: %s", chart, err.Error())
+			exitCode = ExitChartsFailed
+			continue
+		}
+		conf.printChartInfo(chart, fileData)
+	}
+	return exitCode
+}
+
+// printChartInfo prints the metadata and statistics of a parsed chart.
+func (conf *ProgramConfig) printChartInfo(chart string, fileData *BMSFileData) {
+	m := fileData.Metadata
+	conf.Log.HiBlue("%s", chart)
+	printField := func(name string, value string) {
+		if len(value) > 0 {
+			conf.Log.White("  %-14s %s", name+":", value)
+		}
+	}
+	printField("Title", m.Title)
+	printField("Subtitle", m.Subtitle)
+	printField("Artist", AppendSubArtistsToArtist(m.Artist, m.SubArtists))
+	printField("Creator", GetCreator(m))
+	printField("Difficulty", m.Difficulty)
+	printField("Tags", m.Tags)
+	printField("Comment", m.Comment)
+	printField("Stage file", m.StageFile)
+	printField("Banner", m.Banner)
+	printField("Back BMP", m.BackBMP)
+	printField("Background", m.Background)
+	printField("Preview", m.Preview)
+	printField("Preview image", m.PreviewImage)
+	if fileData.PreviewTime >= 0 {
+		printField("Preview time", fmt.Sprintf("%.0f ms", fileData.PreviewTime))
+	}
+
+	minBPM, maxBPM := fileData.StartingBPM, fileData.StartingBPM
+	for _, bpm := range fileData.TimingPoints {
+		minBPM, maxBPM = math.Min(minBPM, bpm), math.Max(maxBPM, bpm)
+	}
+	if minBPM == maxBPM {
+		printField("BPM", fmt.Sprintf("%g", fileData.StartingBPM))
+	} else {
+		printField("BPM", fmt.Sprintf("%g (%g-%g)", fileData.StartingBPM, minBPM, maxBPM))
+	}
+
+	notes, longNotes := 0, 0
+	length := 0.0
+	lanes := make([]string, 0, len(fileData.HitObjects))
+	for _, lane := range GetSortedLanes(fileData.HitObjects) {
+		objects := fileData.HitObjects[lane]
+		for _, o := range objects {
+			notes++
+			if o.IsLongNote {
+				longNotes++
+			}
+			length = math.Max(length, math.Max(o.StartTime, o.EndTime))
+		}
+		name := fmt.Sprintf("%d", lane)
+		if lane == 8 {
+			name = "scratch"
+		}
+		lanes = append(lanes, fmt.Sprintf("%s (%d)", name, len(objects)))
+	}
+	printField("Length", fmt.Sprintf("%d:%02d", int(length/Second)/60, int(length/Second)%60))
+	printField("Lanes", strings.Join(lanes, ", "))
+	printField("Notes", fmt.Sprintf("%d (%d long notes)", notes, longNotes))
+	printField("LN object", fileData.LNObject)
+	printField("Timing points", fmt.Sprintf("%d", len(fileData.TimingPoints)))
+	printField("Samples", fmt.Sprintf("%d", len(fileData.Audio.StringArray)))
+	printField("Sound effects", fmt.Sprintf("%d", len(fileData.SoundEffects)))
+	printField("BGA frames", fmt.Sprintf("%d", len(fileData.BGAFrames)))
+	if fileData.Video != nil {
+		printField("Video", fileData.Video.File)
+	}
+	printField("Text events", fmt.Sprintf("%d", len(fileData.TextEvents)))
+	printField("Missing files", fmt.Sprintf("%d", len(fileData.Assets.Missing)))
+	printField("Unused", fmt.Sprintf("%d definitions", len(fileData.Assets.Unused)))
+	printField("Warnings", fmt.Sprintf("%d", len(fileData.Warnings.Warnings)))
+	if conf.Verbose {
+		for _, w := range fileData.Warnings.Strings() {
+			conf.Log.HiYellow("  * %s", w)
+		}
+	}
+}
+
+// runInfo runs the info subcommand, which prints the timing points of each chart given, as they will be written to
+// converted files.
+func runInfo(args []string) int {
+	conf, charts, ok := parseCommand("info", args)
+	if !ok {
+		return ExitFatal
+	}
+	exitCode := ExitOK
+	for i, chart := range charts {
+		if i > 0 {
+			fmt.Println()
+		}
+		fileData, err := conf.readChart(chart)
+		if err != nil {
+			conf.Log.HiRed("* %s: %s", chart, err.Error())
+			exitCode = ExitChartsFailed
+			continue
+		}
+		conf.Log.HiBlue("%s", chart)
+		conf.Log.HiBlack("  %12s  %10s  %12s", "Time (ms)", "BPM", "Beat (ms)")
+		previous := math.NaN()
+		for _, t := range sortedTimingPoints(fileData) {
+			bpm := fileData.TimingPoints[t]
+			line := fmt.Sprintf("  %12.3f  %10g  %12.3f", t, bpm, GetBeatDuration(bpm))
+			switch {
+			case bpm == 0:
+				conf.Log.HiYellow("%s  stop", line)
+			case bpm != previous:
+				conf.Log.White("%s  %s", line, color.HiGreenString("BPM change"))
+			default:
+				conf.Log.HiBlack("%s", line)
+			}
+			previous = bpm
+		}
+	}
+	return exitCode
+}
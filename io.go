@@ -1,22 +1,20 @@
 package main
 
 import (
+	"io/fs"
 	"os"
 	"path"
-	"path/filepath"
 	"strings"
 )
 
 // BMS #WAV values can have mismatching extensions (sometimes it's .wav when it's actually .ogg on the filesystem).
 // This will correct the extension, or return nothing if it wasn't found.
-func SearchForSoundFile(originalPath string, pathToSoundFile string) string {
+func SearchForSoundFile(input fs.FS, pathToSoundFile string) string {
 	possibleExtensions := []string{".wav", ".mp3", ".ogg", ".3gp"}
 
-	originalPath = filepath.ToSlash(originalPath)
-	pathToSoundFileNoExt := filepath.ToSlash(strings.TrimSuffix(pathToSoundFile, path.Ext(pathToSoundFile)))
+	pathToSoundFileNoExt := strings.ReplaceAll(strings.TrimSuffix(pathToSoundFile, path.Ext(pathToSoundFile)), "\\", "/")
 	for _, extension := range possibleExtensions {
-		_, e := os.Stat(path.Clean(path.Join(originalPath, pathToSoundFileNoExt) + extension))
-		if e != nil {
+		if !FileExistsIn(input, pathToSoundFileNoExt+extension) {
 			continue
 		}
 		return pathToSoundFileNoExt + extension
@@ -30,3 +28,15 @@ func FileExists(location string) bool {
 	_, e := os.Stat(location)
 	return e == nil
 }
+
+// FileExistsIn returns true if the file exists in the input folder (or archive).
+func FileExistsIn(input fs.FS, name string) bool {
+	_, e := fs.Stat(input, FSPath(name))
+	return e == nil
+}
+
+// FSPath turns a location referenced by a chart into one that can be opened from an fs.FS. Charts made on Windows
+// often use backslashes, which fs.FS doesn't accept as separators.
+func FSPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, "\\", "/")), "/")
+}
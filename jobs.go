@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// JobPool limits how many jobs run at the same time across the whole program, including jobs started by other jobs
+// (such as the charts of a folder being converted).
+type JobPool struct {
+	// slots has room for every job except the one calling Run.
+	slots chan struct{}
+}
+
+// NewJobPool returns a pool which runs up to jobs calls at the same time.
+func NewJobPool(jobs int) *JobPool {
+	return &JobPool{slots: make(chan struct{}, MaxInt(jobs-1, 0))}
+}
+
+// Run calls do for every index from 0 to count-1, and returns once every call has returned. The calling goroutine
+// works on the calls itself, and more goroutines only help whenever the pool has a free slot, so calls of Run inside
+// other calls never wait for each other.
+func (p *JobPool) Run(count int, do func(i int)) {
+	next := int64(-1)
+	var wg sync.WaitGroup
+	var work func()
+	work = func() {
+		for {
+			i := int(atomic.AddInt64(&next, 1))
+			if i >= count {
+				return
+			}
+			if i+1 < count {
+				// Take any slot which was freed since, so the remaining calls finish sooner.
+				select {
+				case p.slots <- struct{}{}:
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						defer func() { <-p.slots }()
+						work()
+					}()
+				default:
+				}
+			}
+			do(i)
+		}
+	}
+	work()
+	wg.Wait()
+}
+
+// withBufferedLog returns a copy of the config which logs to a buffer, so its output can be printed in order once
+// it's done.
+func (conf *ProgramConfig) withBufferedLog() (*ProgramConfig, *bytes.Buffer) {
+	var log bytes.Buffer
+	c := *conf
+	c.Log = NewLogger(&log)
+	return &c, &log
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// Logger prints colored messages the same way the color package does, but to any writer. Folders and charts that
+// are converted at the same time each log to their own buffer, so their output doesn't get mixed up.
+type Logger struct {
+	w io.Writer
+}
+
+func NewLogger(w io.Writer) *Logger {
+	return &Logger{w: w}
+}
+
+func (l *Logger) print(attribute color.Attribute, format string, a ...interface{}) {
+	c := color.New(attribute)
+	if !strings.HasSuffix(format, "\n") {
+		format += "\n"
+	}
+	if len(a) == 0 {
+		_, _ = c.Fprint(l.w, format)
+		return
+	}
+	_, _ = c.Fprintf(l.w, format, a...)
+}
+
+func (l *Logger) White(format string, a ...interface{})    { l.print(color.FgWhite, format, a...) }
+func (l *Logger) HiBlack(format string, a ...interface{})  { l.print(color.FgHiBlack, format, a...) }
+func (l *Logger) HiRed(format string, a ...interface{})    { l.print(color.FgHiRed, format, a...) }
+func (l *Logger) HiGreen(format string, a ...interface{})  { l.print(color.FgHiGreen, format, a...) }
+func (l *Logger) HiYellow(format string, a ...interface{}) { l.print(color.FgHiYellow, format, a...) }
+func (l *Logger) HiBlue(format string, a ...interface{})   { l.print(color.FgHiBlue, format, a...) }
+
+// Write writes already formatted messages (e.g. the buffered log of a folder) as they are.
+func (l *Logger) Write(p []byte) (int, error) {
+	return l.w.Write(p)
+}
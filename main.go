@@ -2,17 +2,27 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"flag"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"os"
-	"path"
-	"path/filepath"
 	"strings"
 
 	"github.com/fatih/color"
 )
 
+// Exit codes of the program, so scripts can tell whether the conversion worked.
+const (
+	// ExitOK means every chart was converted (and, with -strict, without any warnings).
+	ExitOK = 0
+
+	// ExitChartsFailed means some charts or folders weren't converted, or there were warnings with -strict.
+	ExitChartsFailed = 1
+
+	// ExitFatal means nothing could be converted, because of invalid arguments or an unreadable input directory.
+	ExitFatal = 2
+)
+
 func welcomeToOss() {
 	fmt.Print("\n")
 	str := `██████╗ ███╗   ███╗████████╗
@@ -30,15 +40,25 @@ func welcomeToOss() {
 }
 
 func main() {
+	os.Exit(runCommand(os.Args[1:]))
+}
 
+// runConvert converts everything in the input directory, and returns the exit code.
+func runConvert(args []string) int {
 	welcomeToOss()
-	conf := NewProgramConfig()
+	conf, err := ParseProgramConfig(flag.NewFlagSet("convert", flag.ExitOnError), args)
+	if err != nil {
+		color.HiRed("* Failed to read the options: %s", err.Error())
+		return ExitFatal
+	}
 
 	if conf.Input == "-" {
-		log.Fatal("Input directory must be provided. Use the argument -i /path/to/input to define a directory with BMS folders.")
+		color.HiRed("* Input directory must be provided. Use the argument -i /path/to/input to define a directory with BMS folders, or a single chart or .zip file.")
+		return ExitFatal
 	}
 	if conf.Output == "-" {
-		log.Fatal("Output directory must be provided. Use the argument -o /path/to/output to define where BMT will output files.")
+		color.HiRed("* Output directory must be provided. Use the argument -o /path/to/output to define where BMT will output files.")
+		return ExitFatal
 	}
 
 	if conf.Verbose {
@@ -60,239 +80,122 @@ func main() {
 	inputExists := FileExists(conf.Input)
 	if !inputExists {
 		color.HiRed("* Input directory does not exist.")
-		return
+		return ExitFatal
 	}
 
 	outputExists := FileExists(conf.Output)
 	if !outputExists {
 		color.HiRed("* Output directory does not exist.")
-		return
+		return ExitFatal
 	}
 
-	// Check if temp folder is still left over
-	_, err := os.Stat(path.Join(filepath.FromSlash(conf.Output), TempDir))
-	if err != nil {
-		if os.IsNotExist(err) {
-			if conf.Verbose {
-				color.HiBlack("* Temporary directory does not exist yet.")
-			}
-		} else {
-			color.HiRed("* Failed to check for existence of the temporary directory. error: %s", err.Error())
-			return
-		}
-	} else {
-		if conf.Verbose {
-			color.HiBlack("* Temporary directory appears to already exist. Attempting to remove")
-		}
-		err = os.RemoveAll(path.Join(filepath.FromSlash(conf.Output), TempDir))
-		if err != nil {
-			color.HiRed("* Failed to remove temporary directory. Location: %s error: %s", path.Join(filepath.FromSlash(conf.Output), TempDir), err.Error())
-			return
-		}
-		if conf.Verbose {
-			color.HiBlack("* Deleted old temporary directory successfully")
-		}
+	if conf.Watch {
+		return conf.WatchInput()
 	}
 
-	// Scan input directory
-	inputFolders, err := ioutil.ReadDir(filepath.FromSlash(conf.Input))
+	// Find every song folder, no matter how deeply it's nested
+	songs, err := conf.DiscoverSongFolders()
 	if err != nil {
-		log.Fatal(err)
+		color.HiRed("* Failed to read the input directory: %s", err.Error())
+		return ExitFatal
 	}
-	if len(inputFolders) == 0 {
-		color.HiRed("* No folders found in input directory.")
-		return
+	if len(songs) == 0 {
+		color.HiRed("* No folders with .bms, .bme or .bml files found in input directory.")
+		return ExitFatal
 	}
 
-	if conf.Verbose {
-		color.HiBlack("* Found %d directories to process.", len(inputFolders))
+	color.White("* Found %d song folders to process:", len(songs))
+	for _, s := range songs {
+		color.White("  %s (%d charts)", s.Relative, len(s.Charts))
 	}
 
-	// Only create TempDir when zipping is enabled
-	if !conf.NoZip {
-		err = os.Mkdir(path.Join(filepath.FromSlash(conf.Output), TempDir), 0700)
-		if err != nil {
-			color.HiRed("* Could not create a temporary folder inside the output directory. %s", err.Error())
-			return
-		}
+	// Folders which didn't change since the last conversion are skipped, unless -force is specified.
+	cache := LoadCache(conf.Output)
+	if conf.Force {
+		cache.Folders = map[string]CacheEntry{}
+	}
+	conversionStatus := conf.ConvertSongFolders(songs, cache)
+	cache.Prune(songs)
+	if err := cache.Save(conf.Output); err != nil {
+		color.HiYellow("* Failed to save %s: %s", CacheFile, err.Error())
 	}
 
-	// Store statuses
-	var conversionStatus []ConversionStatus
-
-	// Iterate over all files
-	for fI, f := range inputFolders {
-		color.White("* [%d/%d] Processing %s", fI+1, len(inputFolders), color.YellowString(f.Name()))
-		conversionStatus = append(conversionStatus, ConversionStatus{})
-		conversionStatus[fI].Name = f.Name()
-		if !f.IsDir() {
-			color.HiRed("* %s is not a directory. Skipping.", f.Name())
-			conversionStatus[fI].Skip = true
-			continue
-		}
-		input := filepath.ToSlash(path.Join(filepath.FromSlash(conf.Input), f.Name()))
-		//output := filepath.ToSlash(path.Join(filepath.FromSlash(conf.Output), TempDir, f.Name()))
-		// choose output path based on -no-zip
-		output := func() string {
-			if conf.NoZip {
-				return filepath.ToSlash(path.Join(filepath.FromSlash(conf.Output), f.Name()))
-			}
-			return filepath.ToSlash(path.Join(filepath.FromSlash(conf.Output), TempDir, f.Name()))
-		}()
-
-		var bmsChartFiles []string
-		files, err := ioutil.ReadDir(input)
-		if err != nil {
-			conversionStatus[fI].Skip = true
-			color.HiRed("* Failed to read directory of %s. Skipping. (Error: %s)", f.Name(), err.Error())
-			continue
-		}
-		// Most BMS zip files appear to be nested :^(
-		if len(files) == 1 && files[0].IsDir() {
-			input = filepath.ToSlash(path.Join(filepath.FromSlash(conf.Input), f.Name(), files[0].Name()))
-			files, err = ioutil.ReadDir(input)
-			if err != nil {
-				conversionStatus[fI].Skip = true
-				color.HiRed("* Failed to read directory of %s. Skipping. (Error: %s)", f.Name(), err.Error())
-				continue
-			}
-		}
-
-		if len(files) == 0 {
-			conversionStatus[fI].Skip = true
-			color.HiRed("* No files are in %s. Skipping.", f.Name())
-			continue
-		}
-
-		// Iterate over all files
-		for _, f := range files {
-			if f.Size() == 0 || f.IsDir() {
-				continue
-			}
-			// include .BME, .Bme, .bMe, .bmE ...
-			lower := strings.ToLower(f.Name())
-			if strings.HasSuffix(lower, ".bms") || strings.HasSuffix(lower, ".bml") || strings.HasSuffix(lower, ".bme") {
-				bmsChartFiles = append(bmsChartFiles, f.Name())
-			}
-		}
-		if len(bmsChartFiles) == 0 {
-			conversionStatus[fI].Skip = true
-			color.HiRed("* Didn't find any .bms, .bme or .bml files in %s. Skipping.", f.Name())
-			continue
-		}
-
-		err = os.Mkdir(output, 0755)
-		if err != nil {
-			color.HiRed("* Failed to create a folder for %s. Skipping. (%s)", f.Name(), err.Error())
-			continue
-		}
-		// Copy all contents
-		//if conf.Verbose {
-		//	color.HiBlack("* Checks passed; copying %s to %s.", input, output)
-		//}
-		//err = copy2.Copy(input, output)
-		//if err != nil {
-		//	conversionStatus[fI].Skip = true
-		//	color.HiRed("* Failed to copy %s. Skipping. (%s)", f.Name(), err.Error())
-		//	continue
-		//}
-
-		if conf.Verbose {
-			color.HiBlack("* Found %d charts to process", len(bmsChartFiles))
-		}
-
-		zipExtension := "qp"
-		fileExtension := "qua"
-		switch conf.FileType {
-		case Osu:
-			zipExtension = "osz"
-			fileExtension = "osu"
-		}
-		for diffIndex, bmsFile := range bmsChartFiles {
-			if conf.Verbose {
-				if conf.JSONOnly {
-					color.HiBlack("* [%d/%d] %s -> .json", diffIndex+1, len(bmsChartFiles), bmsFile)
-				} else {
-					color.HiBlack("* [%d/%d] %s -> .%s ", diffIndex+1, len(bmsChartFiles), bmsFile, fileExtension)
-				}
-			}
-			fileData, err := conf.ReadFileData(input, bmsFile)
-			if err != nil {
-				conversionStatus[fI].Fail++
-				color.HiRed("* %s wasn't parsed due to an error: %s", bmsFile, err.Error())
-				continue
-			}
-			if fileData == nil {
-				color.HiYellow("* %s was skipped", bmsFile)
-				conversionStatus[fI].Fail++
-				continue
-			}
-
-			if conf.FileType == Osu && conf.Verbose {
-				color.HiBlack("* osu! specific: found %d background animation frames", len(fileData.BGAFrames))
-			}
-
-			if conf.JSONOutput || conf.JSONOnly {
-				bmsFileName := strings.TrimSuffix(bmsFile, path.Ext(bmsFile))
-				err = conf.ConvertBmsToJson(*fileData, path.Join(conf.Output, f.Name()+" - "+bmsFileName+".json"))
-				if err != nil && conf.Verbose {
-					color.HiRed("* failed to write json for %s: %s", bmsFile, err.Error())
-				}
-			}
-
-			if !conf.JSONOnly {
-				writeTo := path.Join(output, strings.TrimSuffix(bmsFile, path.Ext(bmsFile))+"."+fileExtension)
-				switch conf.FileType {
-				case Osu:
-					err = conf.ConvertBmsToOsu(*fileData, writeTo)
-					break
-				default:
-					err = conf.ConvertBmsToQua(*fileData, writeTo)
-				}
-				if err != nil {
-					conversionStatus[fI].Fail++
-					color.HiYellow("* %s wasn't written to due to an error: %s", bmsFile, err.Error())
-					continue
-				}
-			}
-
-			conversionStatus[fI].Success++
-		}
+	color.HiGreen("* Finished conversion of all queued folders.")
+	exitCode := conf.PrintSummary(conversionStatus)
 
-		if !conf.JSONOnly && !conf.NoZip {
-			if err := RecursiveMultiPathZip(input, output, path.Join(conf.Output, f.Name()+"."+zipExtension)); err != nil {
-				panic(err)
-			}
-		} else if conf.NoZip {
-			color.HiGreen("`-no-zip` Specified: Conversion output left uncompressed in %q", output)
-			if err := CopyPath(input, output); err != nil {
-				log.Printf("Warning: Failed to copy source files from %q to %q: %v", input, output, err)
-			}
+	if len(conf.Report) > 0 {
+		if err := WriteReport(conf.Report, conversionStatus); err != nil {
+			color.HiRed("* Failed to write the report to %s: %s", conf.Report, err.Error())
+			return ExitFatal
 		}
+		color.HiGreen("* Wrote the report to %s", conf.Report)
+	}
+	return exitCode
+}
 
-		if conf.Verbose {
-			color.HiBlack("* ---- Done with this folder ----")
+// ConvertSongFolders converts every song folder which isn't up to date in the cache, and updates the cache.
+func (conf *ProgramConfig) ConvertSongFolders(songs []SongFolder, cache *Cache) []ConversionStatus {
+	// Folders are converted concurrently, but their logs are printed in order as soon as all folders before them are
+	// done. Each folder only writes its own status, so no locking is needed.
+	conversionStatus := make([]ConversionStatus, len(songs))
+	cacheEntries := make([]CacheEntry, len(songs))
+	logs := make([]*bytes.Buffer, len(songs))
+	done := make([]chan struct{}, len(songs))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	go conf.jobs.Run(len(songs), func(i int) {
+		// With a single job, folders finish in order anyway, so their logs are printed immediately.
+		folderConf, log := conf, (*bytes.Buffer)(nil)
+		if conf.Jobs > 1 {
+			folderConf, log = conf.withBufferedLog()
+		}
+		conversionStatus[i], cacheEntries[i] = folderConf.ConvertFolderIfChanged(songs[i], i, len(songs), cache)
+		logs[i] = log
+		close(done[i])
+	})
+	for i := range songs {
+		<-done[i]
+		if logs[i] != nil {
+			_, _ = logs[i].WriteTo(conf.Log)
+		}
+	}
+
+	for i, entry := range cacheEntries {
+		if len(entry.Key) > 0 {
+			cache.Folders[songs[i].Relative] = entry
+		} else {
+			delete(cache.Folders, songs[i].Relative)
 		}
 	}
+	return conversionStatus
+}
 
-	color.HiGreen("* Finished conversion of all queued folders.")
+// PrintSummary prints how many charts of each folder were converted, and returns the exit code for them.
+func (conf *ProgramConfig) PrintSummary(conversionStatus []ConversionStatus) int {
+	exitCode := ExitOK
+	warnings := 0
 	for _, s := range conversionStatus {
+		warnings += s.Warnings
+		if s.Skip || s.Fail > 0 {
+			exitCode = ExitChartsFailed
+		}
 		if s.Skip {
-			color.HiYellow("* %s was skipped", s.Name)
+			conf.Log.HiYellow("* %s was skipped", s.Name)
 			continue
 		}
-		color.White("* %s: %d %s and %d %s", s.Name, s.Fail, color.YellowString("not converted"), s.Success, color.HiGreenString("succeeded"))
-	}
-
-	// only remove TempDir when zipping was used
-	if !conf.NoZip {
-		e := os.RemoveAll(path.Join(conf.Output, TempDir))
-		if e != nil {
-			color.HiRed("* Failed to remove temp dir. %s", e.Error())
-		} else {
-			if conf.Verbose {
-				color.HiBlack("* Cleaned up temp dir successfully.")
-			}
+		upToDate := ""
+		if s.Cached {
+			upToDate = " (up to date)"
+		}
+		conf.Log.White("* %s: %d %s and %d %s%s", s.Name, s.Fail, color.YellowString("not converted"), s.Success, color.HiGreenString("succeeded"), upToDate)
+		if s.MissingAssets > 0 || s.UnusedDefinitions > 0 || len(s.UnreferencedFiles) > 0 {
+			conf.Log.HiYellow("  %d missing files, %d unused definitions and %d unreferenced files", s.MissingAssets, s.UnusedDefinitions, len(s.UnreferencedFiles))
 		}
 	}
+	if conf.Strict && warnings > 0 {
+		conf.Log.HiRed("* -strict: %d warnings were found", warnings)
+		exitCode = ExitChartsFailed
+	}
+	return exitCode
 }
@@ -1,7 +1,8 @@
 package main
 
 import (
-	"os"
+	"io"
+	"sort"
 	"strings"
 )
 
@@ -34,11 +35,35 @@ func AppendSubArtistsToArtist(a string, subartists []string) string {
 	return a + " <" + strings.Join(subartists, " | ") + ">"
 }
 
-func WriteLine(f *os.File, s string) error {
-	_, e := f.WriteString(s + "\n")
+// GetCreator returns #MAKER if the chart defines it, and otherwise the artist along with all subartists.
+func GetCreator(metadata BMSMetadata) string {
+	if len(metadata.Maker) > 0 {
+		return metadata.Maker
+	}
+	return AppendSubArtistsToArtist(metadata.Artist, metadata.SubArtists)
+}
+
+func WriteLine(w io.Writer, s string) error {
+	_, e := io.WriteString(w, s+"\n")
 	return e
 }
 
+// errorWriter keeps the first error of the writer it wraps and stops writing after it, so the errors of a long series
+// of WriteLine calls only have to be checked once, at the end.
+type errorWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *errorWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, e := w.w.Write(p)
+	w.err = e
+	return n, e
+}
+
 // GetCorrespondingHitSound gets a hexadecimal value's hit sound as a sample index for future reference.
 func (conf *ProgramConfig) GetCorrespondingHitSound(hitSoundHexArray []string, target string) *KeySound {
 	for ind, v := range hitSoundHexArray {
@@ -62,6 +87,14 @@ func ClampInt(i int, max int, min int) int {
 	return i
 }
 
+// MaxInt returns the larger of a and b, e.g. to give an option a lower bound.
+func MaxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 func ClampFloat(i float64, max float64, min float64) float64 {
 	if i > max {
 		return max
@@ -71,3 +104,13 @@ func ClampFloat(i float64, max float64, min float64) float64 {
 	}
 	return i
 }
+
+// GetSortedLanes returns the lanes of the hit objects in order, so output files are always written the same way.
+func GetSortedLanes(hitObjects map[int][]HitObject) []int {
+	lanes := make([]int, 0, len(hitObjects))
+	for lane := range hitObjects {
+		lanes = append(lanes, lane)
+	}
+	sort.Ints(lanes)
+	return lanes
+}
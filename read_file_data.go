@@ -1,11 +1,13 @@
 package main
 
 import (
+	"io/fs"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
-	"github.com/fatih/color"
+	"github.com/vysiondev/bmtranslator/diagnostics"
 )
 
 var (
@@ -16,10 +18,13 @@ var (
 	player2NoteRegex = regexp.MustCompile("[2][1-9]")
 	lnRegex          = regexp.MustCompile("[5][1-z]")
 	player2LnRegex   = regexp.MustCompile("[6][1-9]")
+	// Invisible notes (3x/4x) and landmines (Dx/Ex) aren't converted, but still reference #WAV definitions.
+	unconvertedKeySoundRegex = regexp.MustCompile("[34de][1-9]")
 )
 
-// ReadFileData converts from BMS to a ConvertedFile. Returns a ConvertedFile, whether file was skipped or not, and an error if it errored.
-func (conf *ProgramConfig) ReadFileData(inputPath string, bmsFileName string) (*BMSFileData, error) {
+// ReadFileData converts from BMS to a ConvertedFile. Returns an error (see the diagnostics package) if the chart can't
+// be converted, including when it's skipped on purpose.
+func (conf *ProgramConfig) ReadFileData(input fs.FS, bmsFileName string) (*BMSFileData, error) {
 
 	// What time (ms) the current track will start at.
 	var startTrackAt float64
@@ -32,15 +37,24 @@ func (conf *ProgramConfig) ReadFileData(inputPath string, bmsFileName string) (*
 	longNoteTracker := map[int]float64{}
 	longNoteSoundEffectTracker := map[int]*KeySound{}
 
-	fileData, e := conf.CompileBMSToStruct(inputPath, bmsFileName)
+	fileData, e := conf.CompileBMSToStruct(input, bmsFileName)
 	if e != nil {
-		return nil, e
-	}
-	if fileData == nil {
-		return nil, nil
+		return nil, diagnostics.InFile(e, bmsFileName)
 	}
 	startTrackWithBPM = fileData.StartingBPM
 
+	// Codes of #WAV and #BMP definitions used by any channel, for the asset report.
+	usedSamples := map[string]bool{}
+	usedImages := map[string]bool{}
+
+	// Times where each #SWBGA animation was triggered.
+	switchBGATriggers := map[string][]float64{}
+	switchBGACodes := make([]string, 0, len(fileData.Indices.SwitchBGA))
+	for code := range fileData.Indices.SwitchBGA {
+		switchBGACodes = append(switchBGACodes, code)
+	}
+	sort.Strings(switchBGACodes)
+
 	fileData.TimingPoints[0.0] = fileData.StartingBPM
 
 	// Sort all tracks in ascending order, then iterate through every measure (including empty ones)
@@ -49,6 +63,9 @@ func (conf *ProgramConfig) ReadFileData(inputPath string, bmsFileName string) (*
 		keys = append(keys, k)
 	}
 	sort.Ints(keys)
+	if len(keys) == 0 {
+		return nil, diagnostics.InFile(diagnostics.New(diagnostics.ErrEmptyChart, 0, 0, "chart has no measures"), bmsFileName)
+	}
 
 	// Determine the minimum and maximum measure numbers for continuous iteration
 	minMeasure := keys[0]
@@ -58,10 +75,7 @@ func (conf *ProgramConfig) ReadFileData(inputPath string, bmsFileName string) (*
 		// `trackInt` refers to the current measure (for empty measures, fileData.TrackLines[trackInt] is nil or an empty slice)
 		localTrackData, e := conf.ReadTrackData(trackInt, fileData.TrackLines[trackInt], fileData.Indices.BPMChanges, fileData.Indices.Stops)
 		if e != nil {
-			return nil, e
-		}
-		if localTrackData == nil {
-			return nil, nil
+			return nil, diagnostics.InFile(e, bmsFileName)
 		}
 
 		for _, line := range fileData.TrackLines[trackInt] {
@@ -71,12 +85,18 @@ func (conf *ProgramConfig) ReadFileData(inputPath string, bmsFileName string) (*
 
 			// Cancel parsing if notes are found in P2 side.
 			if player2NoteRegex.MatchString(line.Channel) || player2LnRegex.MatchString(line.Channel) {
-				if conf.Verbose {
-					color.HiYellow("* This map has notes in player 2's side, which would overlap player 1. Not going to process this map.")
-					return nil, nil
+				err := diagnostics.New(diagnostics.ErrPlayer2Notes, line.Number, 5, "channel %s would overlap player 1", line.Channel)
+				return nil, diagnostics.InFile(err, bmsFileName)
+			}
+			if unconvertedKeySoundRegex.MatchString(line.Channel) {
+				for i := 0; i < len(line.Message)/2; i++ {
+					if target := getHexadecimalPair(i, line.Message); target != "00" {
+						usedSamples[target] = true
+					}
 				}
+				continue
 			}
-			if !(noteRegex.MatchString(line.Channel) || lnRegex.MatchString(line.Channel) || line.Channel == "01" || line.Channel == "04" || line.Channel == "07") {
+			if !(noteRegex.MatchString(line.Channel) || lnRegex.MatchString(line.Channel) || line.Channel == "01" || IsBGAChannel(line.Channel) || line.Channel == "97" || line.Channel == "98" || line.Channel == "99") {
 				continue
 			}
 			for i := 0; i < len(line.Message)/2; i++ {
@@ -88,6 +108,47 @@ func (conf *ProgramConfig) ReadFileData(inputPath string, bmsFileName string) (*
 					continue
 				}
 				localOffset := GetOffsetFromStartingTime(localTrackData, i, line.Message, startTrackWithBPM)
+				// BGM (97) and key (98) volume changes
+				if line.Channel == "97" || line.Channel == "98" {
+					v, e := strconv.ParseInt(target, 16, 64)
+					if e != nil {
+						continue
+					}
+					fileData.VolumeChanges = append(fileData.VolumeChanges, VolumeChange{
+						StartTime: startTrackAt + localOffset,
+						Volume:    GetVolumeFromChannel(v),
+						Key:       line.Channel == "98",
+					})
+					continue
+				}
+				// Text messages
+				if line.Channel == "99" {
+					if t, ok := fileData.Indices.Text[target]; ok {
+						fileData.TextEvents = append(fileData.TextEvents, TextEvent{
+							StartTime: startTrackAt + localOffset,
+							Code:      target,
+							Text:      t,
+						})
+					}
+					continue
+				}
+				// Notes trigger #SWBGA animations bound to their key
+				if (noteRegex.MatchString(line.Channel) || lnRegex.MatchString(line.Channel)) && conf.FileType == Osu && !conf.NoStoryboard {
+					for _, code := range switchBGACodes {
+						if fileData.Indices.SwitchBGA[code].Channel[1:] == line.Channel[1:] {
+							switchBGATriggers[code] = append(switchBGATriggers[code], startTrackAt+localOffset)
+						}
+					}
+				}
+				if line.Channel == "01" || noteRegex.MatchString(line.Channel) || lnRegex.MatchString(line.Channel) {
+					usedSamples[target] = true
+				}
+				if _, ok := bgaFrameChannels[line.Channel]; ok {
+					usedImages[target] = true
+					if d, ok := fileData.Indices.BGADefinitions[target]; ok {
+						usedImages[d.Source] = true
+					}
+				}
 				sfx := conf.GetCorrespondingHitSound(fileData.Audio.HexadecimalArray, target)
 				laneInt := strings.Index(Base36Range, line.Channel[1:])
 				// maybe you should get among some bitches
@@ -102,8 +163,8 @@ func (conf *ProgramConfig) ReadFileData(inputPath string, bmsFileName string) (*
 							laneInt -= 2
 						}
 						if laneInt > 8 {
-							color.HiRed("* File wants more than 8 keys, skipping")
-							return nil, nil
+							err := diagnostics.New(diagnostics.ErrTooManyKeys, line.Number, 8+i*2, "channel %s is past the 8th key", line.Channel)
+							return nil, diagnostics.InFile(err, bmsFileName)
 						}
 						hitObject := HitObject{
 							StartTime: startTrackAt + localOffset,
@@ -170,6 +231,7 @@ func (conf *ProgramConfig) ReadFileData(inputPath string, bmsFileName string) (*
 					// Sound effect (channel 01)
 					soundEffect := SoundEffect{
 						StartTime: startTrackAt + localOffset,
+						FromKey:   line.Channel != "01",
 					}
 					if sfx != nil {
 						soundEffect.Sample = sfx.Sample
@@ -180,18 +242,31 @@ func (conf *ProgramConfig) ReadFileData(inputPath string, bmsFileName string) (*
 					}
 					fileData.SoundEffects = append(fileData.SoundEffects, soundEffect)
 				}
-				if (line.Channel == "04" || line.Channel == "07") && conf.FileType == Osu && !conf.NoStoryboard {
-					t := fileData.Indices.BGA[target]
-					l := Back
-					if line.Channel == "07" {
-						l = Front
-					}
-					if len(t) > 0 {
-						fileData.BGAFrames = append(fileData.BGAFrames, BGAFrame{
-							StartTime: startTrackAt + localOffset,
-							File:      t,
-							Layer:     l,
-						})
+				if IsBGAChannel(line.Channel) && conf.FileType == Osu && !conf.NoStoryboard {
+					if l, ok := bgaFrameChannels[line.Channel]; ok {
+						if frame, ok := getBGAFrame(fileData, target); ok {
+							frame.StartTime = startTrackAt + localOffset
+							frame.Layer = l
+							frame.Line = line.Number
+							fileData.BGAFrames = append(fileData.BGAFrames, frame)
+						}
+					} else if l, ok := bgaOpacityChannels[line.Channel]; ok {
+						if v, e := strconv.ParseInt(target, 16, 64); e == nil {
+							opacity := float64(v) / 255.0
+							fileData.BGAStateChanges = append(fileData.BGAStateChanges, BGAStateChange{
+								StartTime: startTrackAt + localOffset,
+								Layer:     l,
+								Opacity:   &opacity,
+							})
+						}
+					} else if l, ok := bgaColorChannels[line.Channel]; ok {
+						if c, ok := fileData.Indices.ARGB[target]; ok {
+							fileData.BGAStateChanges = append(fileData.BGAStateChanges, BGAStateChange{
+								StartTime: startTrackAt + localOffset,
+								Layer:     l,
+								Color:     &c,
+							})
+						}
 					}
 				}
 			}
@@ -218,9 +293,52 @@ func (conf *ProgramConfig) ReadFileData(inputPath string, bmsFileName string) (*
 		}
 	}
 
-	sort.Slice(fileData.BGAFrames, func(i, j int) bool {
+	// Animations can have a huge amount of frames (e.g. a tiny frame duration, looped for a long time), so they are
+	// limited per trigger and per chart.
+	chartEndTime := getChartEndTime(*fileData)
+	switchBGAFrames := 0
+	for _, code := range switchBGACodes {
+		sw := fileData.Indices.SwitchBGA[code]
+		if len(switchBGATriggers[code]) > 0 {
+			for _, p := range sw.Pattern {
+				usedImages[p] = true
+			}
+		}
+		truncated := false
+		for _, t := range switchBGATriggers[code] {
+			frames, complete := GetSwitchBGAFrames(sw, fileData.Indices.BGA, t, chartEndTime, ClampInt(SwitchBGAMaxChartFrames-switchBGAFrames, SwitchBGAMaxFrames, 0))
+			fileData.BGAFrames = append(fileData.BGAFrames, frames...)
+			switchBGAFrames += len(frames)
+			truncated = truncated || !complete
+		}
+		if truncated {
+			fileData.Warnings.Add(sw.Line, 0, "SWBGA%s has too many frames, so some of them were left out", strings.ToUpper(code))
+		}
+	}
+	// #BMP00 is shown on a miss when no poor frames are defined.
+	if conf.FileType == Osu && !conf.NoStoryboard && len(fileData.Indices.BGA["00"]) > 0 && !hasLayer(fileData.BGAFrames, Poor) {
+		fileData.BGAFrames = append(fileData.BGAFrames, BGAFrame{
+			File:    fileData.Indices.BGA["00"],
+			Layer:   Poor,
+			Opacity: 1.0,
+		})
+	}
+	sort.SliceStable(fileData.BGAFrames, func(i, j int) bool {
 		return fileData.BGAFrames[i].StartTime < fileData.BGAFrames[j].StartTime
 	})
+	sort.SliceStable(fileData.BGAStateChanges, func(i, j int) bool {
+		return fileData.BGAStateChanges[i].StartTime < fileData.BGAStateChanges[j].StartTime
+	})
+	fileData.BGAFrames = ApplyBGAStateChanges(fileData.BGAFrames, fileData.BGAStateChanges)
+	conf.SelectBackgroundVideo(input, fileData)
+	fileData.BGAFrames = CoalesceBGAFrames(fileData.BGAFrames)
+	sort.SliceStable(fileData.TextEvents, func(i, j int) bool {
+		return fileData.TextEvents[i].StartTime < fileData.TextEvents[j].StartTime
+	})
+
+	fileData.Assets.FindUnusedDefinitions(fileData, usedSamples, usedImages)
+	conf.ApplyVolumes(fileData)
+	fileData.PreviewTime = GetPreviewTime(*fileData)
 
 	return fileData, nil
 }
@@ -1,9 +1,10 @@
 package main
 
 import (
-	"github.com/fatih/color"
 	"sort"
 	"strconv"
+
+	"github.com/vysiondev/bmtranslator/diagnostics"
 )
 
 func (conf *ProgramConfig) ReadTrackData(trackNumber int, lines []Line, bpmChangeIndex map[string]float64, stopIndex map[string]float64) (*LocalTrackData, error) {
@@ -16,16 +17,10 @@ func (conf *ProgramConfig) ReadTrackData(trackNumber int, lines []Line, bpmChang
 		case "02":
 			i, e := strconv.ParseFloat(line.Message, 64)
 			if e != nil {
-				if conf.Verbose {
-					color.HiRed("* Measure scale is invalid. cannot continue parsing (Track: %d)", trackNumber)
-				}
-				return nil, nil
+				return nil, diagnostics.New(diagnostics.ErrInvalidMeasure, line.Number, 8, "measure scale of measure %d isn't a number", trackNumber)
 			}
 			if i <= 0.0 {
-				if conf.Verbose {
-					color.HiRed("* Measure scale is negative or 0. cannot continue parsing (Track: %d)", trackNumber)
-				}
-				return nil, nil
+				return nil, diagnostics.New(diagnostics.ErrInvalidMeasure, line.Number, 8, "measure scale of measure %d is negative or 0", trackNumber)
 			}
 			localTrackData.MeasureScale = i
 			continue
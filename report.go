@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/vysiondev/bmtranslator/diagnostics"
+)
+
+const (
+	ChartConverted = "converted"
+	ChartSkipped   = "skipped"
+	ChartFailed    = "failed"
+)
+
+// Reasons for charts which failed for a reason other than one of the diagnostics errors.
+const (
+	ReasonReadFailed          = "read_failed"
+	ReasonWriteFailed         = "write_failed"
+	ReasonPackageFailed       = "package_failed"
+	ReasonFolderUnreadable    = "folder_unreadable"
+	ReasonFolderConfigInvalid = "folder_config_invalid"
+)
+
+// ChartReport is what happened to a single chart, written to the report file with -report.
+type ChartReport struct {
+	// Folder is the song folder of the chart, relative to the input directory.
+	Folder string `json:"folder"`
+
+	// Chart is the file name of the chart, or empty if the whole folder couldn't be read.
+	Chart string `json:"chart,omitempty"`
+
+	// Status is one of ChartConverted, ChartSkipped or ChartFailed.
+	Status string `json:"status"`
+
+	// Reason, Message and Line describe why the chart was skipped or failed.
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+	Line    int    `json:"line,omitempty"`
+
+	// Warnings are problems which didn't stop the chart from being converted, such as missing files.
+	Warnings []string `json:"warnings"`
+
+	// Output is where the converted chart was written to; for .osz and .qp files, the chart is inside the archive.
+	Output string `json:"output,omitempty"`
+}
+
+// fail marks the chart as skipped if err is one of the diagnostics errors, or failed with the reason otherwise.
+func (r *ChartReport) fail(err error, reason string) {
+	r.Status, r.Reason, r.Message = ChartFailed, reason, err.Error()
+	if skipReason := diagnostics.Reason(err); len(skipReason) > 0 {
+		r.Status, r.Reason = ChartSkipped, skipReason
+	}
+	var chartErr *diagnostics.ChartError
+	if errors.As(err, &chartErr) {
+		r.Line = chartErr.Line
+	}
+}
+
+// WriteReport writes every chart of the conversion to the file, as CSV if it ends in .csv and as JSON otherwise.
+func WriteReport(location string, statuses []ConversionStatus) error {
+	charts := make([]ChartReport, 0)
+	for _, s := range statuses {
+		charts = append(charts, s.Charts...)
+	}
+	f, e := os.Create(filepath.FromSlash(location))
+	if e != nil {
+		return e
+	}
+	if strings.EqualFold(filepath.Ext(location), ".csv") {
+		e = writeReportCSV(f, charts)
+	} else {
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		e = encoder.Encode(charts)
+	}
+	if e != nil {
+		_ = f.Close()
+		return e
+	}
+	return f.Close()
+}
+
+func writeReportCSV(f *os.File, charts []ChartReport) error {
+	w := csv.NewWriter(f)
+	_ = w.Write([]string{"folder", "chart", "status", "reason", "message", "line", "warnings", "output"})
+	for _, c := range charts {
+		line := ""
+		if c.Line > 0 {
+			line = strconv.Itoa(c.Line)
+		}
+		_ = w.Write([]string{c.Folder, c.Chart, c.Status, c.Reason, c.Message, line, strings.Join(c.Warnings, "; "), c.Output})
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// ConversionServer converts zipped BMS folders uploaded over HTTP (see runServe).
+type ConversionServer struct {
+	// conf contains the default options, which can be changed by each request.
+	conf *ProgramConfig
+
+	// maxUpload is the largest request accepted, in bytes.
+	maxUpload int64
+}
+
+// ConvertResponse is the response of /convert.
+type ConvertResponse struct {
+	Converted    int             `json:"converted"`
+	NotConverted int             `json:"not_converted"`
+	Charts       []ChartReport   `json:"charts"`
+	Files        []ConvertedFile `json:"files"`
+}
+
+// ConvertedFile is a file written by a conversion, such as a .osz or .qp file. Data is base64 encoded in JSON.
+type ConvertedFile struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+	Data   []byte `json:"data"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// runServe runs the serve subcommand, which converts BMS folders uploaded to /convert until the program is
+// interrupted. It takes the same options as converting from the command line (except -i and -o), which are the
+// defaults for every request.
+func runServe(args []string) int {
+	set := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := set.String("addr", "127.0.0.1:8080", "Where to listen for requests. Only listens on this computer by default.")
+	maxUpload := set.Int("max-upload", 512, "The largest upload accepted, in megabytes. (512 default)")
+	welcomeToOss()
+	conf, err := ParseProgramConfig(set, args)
+	if err != nil {
+		color.HiRed("* Failed to read the options: %s", err.Error())
+		return ExitFatal
+	}
+	s := &ConversionServer{conf: conf, maxUpload: int64(MaxInt(*maxUpload, 1)) << 20}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/convert", s.handleConvert)
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok\n")
+	})
+	server := &http.Server{Addr: *addr, Handler: mux, ReadHeaderTimeout: 30 * time.Second}
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	go func() {
+		<-interrupted
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}()
+
+	color.HiBlue("* Listening on http://%s. POST a zipped BMS folder to /convert. Press Ctrl+C to stop.", *addr)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		color.HiRed("* Failed to listen on %s: %s", *addr, err.Error())
+		return ExitFatal
+	}
+	color.HiBlue("* Stopped listening on %s", *addr)
+	return ExitOK
+}
+
+// handleConvert converts a zipped BMS folder, uploaded as the "file" field of a multipart form. Other fields are
+// options, named like the flags (e.g. type=osu). The response is a ConvertResponse, or with ?download=1 the only
+// file written.
+func (s *ConversionServer) handleConvert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{"use POST"})
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxUpload)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	upload, header, err := r.FormFile("file")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{"the zipped BMS folder has to be uploaded as \"file\""})
+		return
+	}
+	defer upload.Close()
+	name := path.Base(filepath.ToSlash(header.Filename))
+	if !IsZipFile(name) {
+		writeJSON(w, http.StatusBadRequest, errorResponse{"only .zip files can be converted"})
+		return
+	}
+
+	options := map[string]string{}
+	for option, values := range r.MultipartForm.Value {
+		options[option] = values[len(values)-1]
+	}
+	conf, err := s.conf.withOptions(options, nil)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "bmt-serve-")
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+		return
+	}
+	defer os.RemoveAll(dir)
+	conf.Input, conf.Output = filepath.Join(dir, "in"), filepath.Join(dir, "out")
+	if err := saveUpload(upload, filepath.Join(conf.Input, name), conf.Output); err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+		return
+	}
+
+	// The log of each request is left out of the server's log.
+	conf, _ = conf.withBufferedLog()
+	songs, err := conf.DiscoverSongFolders()
+	if err != nil || len(songs) == 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{"no .bms, .bme or .bml files were found in " + name})
+		return
+	}
+	response := ConvertResponse{Charts: make([]ChartReport, 0), Files: make([]ConvertedFile, 0)}
+	for _, status := range conf.ConvertSongFolders(songs, &Cache{Folders: map[string]CacheEntry{}}) {
+		response.Converted += status.Success
+		response.NotConverted += status.Fail
+		for _, c := range status.Charts {
+			if rel, e := filepath.Rel(conf.Output, filepath.FromSlash(c.Output)); e == nil && len(c.Output) > 0 {
+				c.Output = filepath.ToSlash(rel)
+			}
+			response.Charts = append(response.Charts, c)
+		}
+	}
+	if response.Files, err = readConvertedFiles(conf.Output); err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+		return
+	}
+	s.conf.Log.White("* [%s] %s: %d converted and %d not converted", time.Now().Format("15:04:05"), name, response.Converted, response.NotConverted)
+
+	if len(r.URL.Query().Get("download")) == 0 {
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+	if len(response.Files) != 1 {
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{strconv.Itoa(len(response.Files)) + " files were written; leave out ?download to get all of them"})
+		return
+	}
+	f := response.Files[0]
+	contentType := "application/zip"
+	if path.Ext(f.Name) == ".json" {
+		contentType = "application/json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(f.Name)}))
+	w.Header().Set("X-Charts-Converted", strconv.Itoa(response.Converted))
+	w.Header().Set("X-Charts-Not-Converted", strconv.Itoa(response.NotConverted))
+	_, _ = w.Write(f.Data)
+}
+
+// saveUpload writes the uploaded file to the location, and creates the output folder.
+func saveUpload(upload io.Reader, location string, output string) error {
+	if err := os.MkdirAll(filepath.Dir(location), 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(output, 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(location)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, upload); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readConvertedFiles returns every file written to the output folder, except the cache manifest.
+func readConvertedFiles(output string) ([]ConvertedFile, error) {
+	files := make([]ConvertedFile, 0)
+	err := fs.WalkDir(os.DirFS(output), ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || name == CacheFile {
+			return err
+		}
+		data, err := os.ReadFile(filepath.Join(output, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(data)
+		files = append(files, ConvertedFile{Name: name, SHA256: hex.EncodeToString(hash[:]), Data: data})
+		return nil
+	})
+	return files, err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -1,11 +1,23 @@
 package main
 
+import (
+	"image"
+
+	"github.com/vysiondev/bmtranslator/diagnostics"
+)
+
 // Layer is the storyboard layer type to use for osu!.
 type Layer int
 
 const (
 	Back Layer = iota
 	Front
+	// Front2 is the second layer (channel 0A), shown above Front.
+	Front2
+	// Poor is shown instead of Back when the player misses (channel 06).
+	Poor
+	// Switch is used for key triggered animations (#SWBGA), shown above Front2.
+	Switch
 )
 
 // BMSFileData shows most things you'd want to know about a bms file.
@@ -37,11 +49,42 @@ type BMSFileData struct {
 	// This is only applicable to osu! or if the file is being output to JSON.
 	BGAFrames []BGAFrame
 
+	// CroppedImages contains every image that has to be generated by cropping (see BGADefinition) for BGAFrames.
+	CroppedImages []CroppedImage
+
+	// BGAStateChanges contains all opacity and color changes of BGA layers. These are already applied to BGAFrames.
+	BGAStateChanges []BGAStateChange
+
+	// Video is the video played in the background, or nil if the chart has no video BGA.
+	Video *BackgroundVideo
+
+	// DroppedVideos contains every other video BGA, which couldn't be used since only one video can play.
+	DroppedVideos []string
+
 	// Audio contains information about the file's audio. Ref AudioData for more information.
 	Audio AudioData
 
 	// Indices contains a list of indexes mapping hexadecimal codes to values.
 	Indices IndexData
+
+	// TextEvents contains all messages shown during the chart (channel 99), in order.
+	TextEvents []TextEvent
+
+	// VolWav is the volume of all key sounds and sound effects, as a percentage (#VOLWAV). Defaults to 100.
+	VolWav float64
+
+	// VolumeChanges contains all BGM (channel 97) and key (channel 98) volume changes, in order.
+	VolumeChanges []VolumeChange
+
+	// Assets lists files the chart references which are missing, and definitions it never uses.
+	Assets AssetReport
+
+	// Warnings are problems found in the chart which didn't stop it from being converted.
+	Warnings diagnostics.Collector `json:"-"`
+
+	// PreviewTime is the time, in milliseconds, where song select should start previewing the chart.
+	// It is -1 if no preview time could be determined.
+	PreviewTime float64
 }
 
 // AudioData contains data about the BMS file's audio, EXCEPT for sound effects, which
@@ -62,6 +105,19 @@ type IndexData struct {
 
 	// BGA maps hexadecimal codes to a file path.
 	BGA map[string]string
+
+	// Text maps hexadecimal codes to a message (#TEXT and #SONG).
+	Text map[string]string
+
+	// ARGB maps hexadecimal codes to a color (#ARGB).
+	ARGB map[string]ARGB
+
+	// BGADefinitions maps hexadecimal codes to a cropped region of a #BMP image (#BGA). These codes can be used
+	// in BGA channels the same way #BMP codes are.
+	BGADefinitions map[string]BGADefinition
+
+	// SwitchBGA maps hexadecimal codes to key triggered animations (#SWBGA).
+	SwitchBGA map[string]SwitchBGA
 }
 
 // BGAFrame is a specific BGA frame of the chart.
@@ -74,6 +130,25 @@ type BGAFrame struct {
 
 	// Layer is what hypothetical z-index should be used (only applicable to osu!).
 	Layer Layer `json:"layer"`
+
+	// Opacity of the frame, from 0.0 to 1.0 (channels 0B-0E).
+	Opacity float64 `json:"opacity"`
+
+	// Color is the tint of the frame (#ARGB), or nil if it isn't tinted.
+	Color *ARGB `json:"color,omitempty"`
+
+	// EndTime is when the frame disappears, even if no other frame replaces it. 0 if the frame stays until then.
+	EndTime float64 `json:"end_time,omitempty"`
+
+	// Offset is where the top left corner of the frame is placed in the BGA area (#BGA), or nil if the frame
+	// is centered like a regular #BMP.
+	Offset *image.Point `json:"offset,omitempty"`
+
+	// Animation is set if this frame is a series of frames combined into an animation (see CoalesceBGAFrames).
+	Animation *BGAAnimation `json:"animation,omitempty"`
+
+	// Line is the line of the chart which shows the frame, for warnings about it. 0 if no line does.
+	Line int `json:"-"`
 }
 
 // BMSMetadata contains the general metadata of the map, and does not contain any technical
@@ -106,6 +181,27 @@ type BMSMetadata struct {
 	// Banner is used in the song select screen and, for some clients, the image
 	// which appears while the chart is loading.
 	Banner string `json:"banner"`
+
+	// Maker is the person who made the chart (#MAKER). Used as the creator when present.
+	Maker string `json:"maker"`
+
+	// Comment is any comment left by the chart author (#COMMENT).
+	Comment string `json:"comment"`
+
+	// Preview is the audio file (#PREVIEW) BMS clients play on the song select screen.
+	Preview string `json:"preview"`
+
+	// BackBMP is the image displayed behind the playfield when no BGA is playing.
+	BackBMP string `json:"back_bmp"`
+
+	// PreviewImage is used by charts which define an image for #PREVIEW instead of audio.
+	PreviewImage string `json:"preview_image"`
+
+	// Artwork contains every image above, along with its dimensions.
+	Artwork []Artwork `json:"artwork"`
+
+	// Background is the image from Artwork chosen as the background for the output file type.
+	Background string `json:"background"`
 }
 
 // SoundEffect is a sound effect which will always play at the start time given.
@@ -119,6 +215,34 @@ type SoundEffect struct {
 
 	// Volume is the volume of this sound effect. Can be from 0 to 100.
 	Volume int `json:"volume"`
+
+	// FromKey is true if this sound effect was a note in the scratch lane (see -auto-scratch), which means
+	// key volume changes apply to it rather than BGM volume changes.
+	FromKey bool `json:"-"`
+}
+
+// TextEvent is a message which is shown from its start time until the next message.
+type TextEvent struct {
+	// StartTime is the time, in milliseconds, where the message appears.
+	StartTime float64 `json:"start_time"`
+
+	// Code is the hexadecimal code the message was defined with.
+	Code string `json:"code"`
+
+	// Text is the message itself.
+	Text string `json:"text"`
+}
+
+// VolumeChange changes the volume of either sound effects or key sounds from its start time onwards.
+type VolumeChange struct {
+	// StartTime is the time, in milliseconds, where the volume changes.
+	StartTime float64 `json:"start_time"`
+
+	// Volume is the multiplier applied to the volume from this point on (0.0-1.0).
+	Volume float64 `json:"volume"`
+
+	// Key is true if key sounds are affected (channel 98), and false if sound effects are (channel 97).
+	Key bool `json:"key"`
 }
 
 // HitObject is a note or long note in the chart.
@@ -155,6 +279,9 @@ type Line struct {
 
 	// The message of the line.
 	Message string `json:"message"`
+
+	// Number is the line number in the chart.
+	Number int `json:"-"`
 }
 
 // LocalBPMChange represents a BPM change (or exBPM) which occurs within a specific track.
@@ -208,4 +335,25 @@ type ConversionStatus struct {
 
 	// Skip is true when the folder was skipped altogether.
 	Skip bool
+
+	// MissingAssets is how many files referenced by the folder's charts don't exist.
+	MissingAssets int
+
+	// UnusedDefinitions is how many #WAV/#BMP definitions in the folder's charts are never used.
+	UnusedDefinitions int
+
+	// UnreferencedFiles contains every file in the folder which no chart references.
+	UnreferencedFiles []string
+
+	// Warnings is how many problems were found in the folder which didn't stop its charts from being converted.
+	Warnings int
+
+	// Outputs contains every file and folder written for the folder.
+	Outputs []string
+
+	// Cached is true when the folder wasn't converted again, since its output was up to date (see Cache).
+	Cached bool `json:"-"`
+
+	// Charts contains what happened to each chart in the folder, for -report.
+	Charts []ChartReport
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"path"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// TextSpriteDir is the folder (inside the output folder) where rendered text events are placed.
+	TextSpriteDir = "bmt_text"
+
+	// TextSpriteScale is how much text rendered with the 7x13 font is enlarged by.
+	TextSpriteScale = 2
+
+	// TextDisplayDuration is how long, in milliseconds, the last text event stays on screen.
+	TextDisplayDuration = 5.0 * Second
+)
+
+// TextSpriteFile is the location of the image for the text, relative to the output folder. The location depends
+// only on the text, so charts defining different messages with the same code don't overwrite each other's images,
+// and charts showing the same message share one.
+func TextSpriteFile(text string) string {
+	h := sha1.Sum([]byte(text))
+	return path.Join(TextSpriteDir, hex.EncodeToString(h[:])[:12]+".png")
+}
+
+// CanRenderText returns true if all characters in the text can be drawn with the built-in font.
+// Only printable ASCII is supported, so Japanese text events can't be put into the storyboard.
+func CanRenderText(text string) bool {
+	if len(strings.TrimSpace(text)) == 0 {
+		return false
+	}
+	for _, r := range text {
+		if r < ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// RenderText draws the text in white onto a transparent image, enlarged by TextSpriteScale.
+func RenderText(text string) image.Image {
+	face := basicfont.Face7x13
+	width := font.MeasureString(face, text).Ceil()
+	height := face.Metrics().Height.Ceil()
+
+	small := image.NewRGBA(image.Rect(0, 0, width, height))
+	d := &font.Drawer{
+		Dst:  small,
+		Src:  image.NewUniform(color.White),
+		Face: face,
+		Dot:  fixed.P(0, face.Metrics().Ascent.Ceil()),
+	}
+	d.DrawString(text)
+
+	big := image.NewRGBA(image.Rect(0, 0, width*TextSpriteScale, height*TextSpriteScale))
+	draw.NearestNeighbor.Scale(big, big.Bounds(), small, small.Bounds(), draw.Src, nil)
+	return big
+}
+
+// GenerateTextSprites renders every text event which can be rendered to its image (see TextSpriteFile) in the archive,
+// unless another chart already did.
+func GenerateTextSprites(archive ArchiveWriter, events []TextEvent) error {
+	for _, t := range events {
+		if !CanRenderText(t.Text) || archive.Exists(TextSpriteFile(t.Text)) {
+			continue
+		}
+		if e := writeImage(archive, TextSpriteFile(t.Text), RenderText(t.Text)); e != nil {
+			return e
+		}
+	}
+	return nil
+}
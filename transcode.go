@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"path"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// UnsupportedImageExtensions are image formats which osu! and Quaver either load slowly or not at all.
+// Images with these extensions are converted to .png when image conversion is enabled.
+var UnsupportedImageExtensions = []string{".bmp", ".gif", ".webp", ".tif", ".tiff"}
+
+func isUnsupportedImage(file string) bool {
+	ext := strings.ToLower(path.Ext(file))
+	for _, e := range UnsupportedImageExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// TranscodeImages converts every unsupported image in the input to .png, and downscales images larger than
+// conf.MaxImageSize, writing the results to the same relative location in the archive (as .png, unless they were
+// .png or .jpg already). It returns a map of
+// every original file (relative, slash separated) that was replaced by a file with a different name.
+func (conf *ProgramConfig) TranscodeImages(input fs.FS, archive ArchiveWriter) (map[string]string, error) {
+	replaced := map[string]string{}
+	err := fs.WalkDir(input, ".", func(rel string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		convert := conf.ConvertImages && isUnsupportedImage(rel)
+		if !convert && !(conf.MaxImageSize > 0 && IsImageFile(rel)) {
+			return nil
+		}
+		img, err := readImage(input, rel)
+		if err != nil {
+			conf.Log.HiYellow("* Couldn't decode %s, leaving it as is (%s)", rel, err.Error())
+			return nil
+		}
+		resized := conf.fitImage(img)
+		if !convert && resized == img {
+			return nil
+		}
+
+		// Downscaled images keep their name only if writeImage can encode them in the same format.
+		target := rel
+		if convert || !canEncodeImage(rel) {
+			target = strings.TrimSuffix(rel, path.Ext(rel)) + ".png"
+			// Don't overwrite a .png that already exists alongside the original.
+			if FileExistsIn(input, target) {
+				other := target
+				target = strings.TrimSuffix(rel, path.Ext(rel)) + ".bmt.png"
+				conf.Log.HiYellow("* %s already exists, so %s was written to %s instead", other, rel, target)
+			}
+		}
+		if err := writeImage(archive, target, resized); err != nil {
+			return err
+		}
+		if target != rel {
+			replaced[rel] = target
+		}
+		if conf.Verbose {
+			conf.Log.HiBlack("* Transcoded %s -> %s", rel, target)
+		}
+		return nil
+	})
+	return replaced, err
+}
+
+func readImage(input fs.FS, name string) (image.Image, error) {
+	f, e := input.Open(FSPath(name))
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+	img, _, e := image.Decode(f)
+	return img, e
+}
+
+// fitImage downscales the image so neither side is longer than conf.MaxImageSize, keeping the aspect ratio.
+// The same image is returned if it already fits.
+func (conf *ProgramConfig) fitImage(img image.Image) image.Image {
+	b := img.Bounds()
+	if conf.MaxImageSize <= 0 || (b.Dx() <= conf.MaxImageSize && b.Dy() <= conf.MaxImageSize) {
+		return img
+	}
+	w, h := conf.MaxImageSize, conf.MaxImageSize
+	if b.Dx() > b.Dy() {
+		h = ClampInt(b.Dy()*conf.MaxImageSize/b.Dx(), conf.MaxImageSize, 1)
+	} else {
+		w = ClampInt(b.Dx()*conf.MaxImageSize/b.Dy(), conf.MaxImageSize, 1)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// canEncodeImage returns true if writeImage encodes the image in the format its extension says.
+func canEncodeImage(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".png", ".jpg", ".jpeg":
+		return true
+	}
+	return false
+}
+
+// writeImage adds the image to the archive, encoded as .jpg if the name ends with .jpg/.jpeg, and .png otherwise.
+func writeImage(archive ArchiveWriter, name string, img image.Image) error {
+	f, e := archive.Create(name)
+	if e != nil {
+		return e
+	}
+	defer f.Abort()
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg":
+		e = jpeg.Encode(f, img, &jpeg.Options{Quality: 90})
+	default:
+		e = png.Encode(f, img)
+	}
+	if e != nil {
+		return e
+	}
+	return f.Close()
+}
+
+// RewriteAssetReferences updates every .osu, .osb and .qua file in the root of the archive so references to replaced files
+// (see TranscodeImages) point to their new names. Quoted file names (osu! events and storyboard lines) and
+// BackgroundFile/BannerFile (Quaver) are rewritten.
+func RewriteAssetReferences(archive ArchiveWriter, replaced map[string]string) error {
+	if len(replaced) == 0 {
+		return nil
+	}
+	// BMS files aren't consistent with case or path separators, so compare loosely.
+	lookup := map[string]string{}
+	for k, v := range replaced {
+		// Only what follows the name of the original is replaced (e.g. ".png" or ".bmt.png"), keeping the case of
+		// each reference.
+		suffix := path.Ext(v)
+		if base := strings.TrimSuffix(k, path.Ext(k)); strings.HasPrefix(v, base) {
+			suffix = strings.TrimPrefix(v, base)
+		}
+		lookup[normalizeAssetPath(k)] = suffix
+	}
+	rename := func(ref string) string {
+		if ext, ok := lookup[normalizeAssetPath(ref)]; ok {
+			return strings.TrimSuffix(ref, path.Ext(ref)) + ext
+		}
+		return ref
+	}
+
+	for _, name := range archive.Files() {
+		ext := strings.ToLower(path.Ext(name))
+		if !isTopLevel(name) || (ext != ".osu" && ext != ".osb" && ext != ".qua") {
+			continue
+		}
+		lines, err := readLines(archive, name)
+		if err != nil {
+			return err
+		}
+		for i, line := range lines {
+			if ext == ".qua" {
+				for _, key := range []string{"BackgroundFile: ", "BannerFile: "} {
+					if strings.HasPrefix(line, key) {
+						lines[i] = key + rename(strings.TrimPrefix(line, key))
+					}
+				}
+				continue
+			}
+			// osu! references files as "file"
+			parts := strings.Split(line, "\"")
+			for j := 1; j < len(parts); j += 2 {
+				parts[j] = rename(parts[j])
+			}
+			lines[i] = strings.Join(parts, "\"")
+		}
+		if err := writeLines(archive, name, lines); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func normalizeAssetPath(p string) string {
+	return strings.ToLower(strings.ReplaceAll(p, "\\", "/"))
+}
+
+func readLines(archive ArchiveWriter, name string) ([]string, error) {
+	f, e := archive.Open(name)
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+func writeLines(archive ArchiveWriter, name string, lines []string) error {
+	f, e := archive.Create(name)
+	if e != nil {
+		return e
+	}
+	defer f.Abort()
+	for _, l := range lines {
+		if e = WriteLine(f, l); e != nil {
+			return e
+		}
+	}
+	return f.Close()
+}
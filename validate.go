@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path"
+
+	"github.com/fatih/color"
+)
+
+// runValidate runs the validate subcommand, which parses every chart in the files given (charts, song folders,
+// folders of song folders or .zip files) and reports their problems without converting anything. Charts which can't
+// be converted make it exit with ExitChartsFailed, as do warnings with -strict.
+func runValidate(args []string) int {
+	conf, locations, ok := parseCommand("validate", args)
+	if !ok {
+		return ExitFatal
+	}
+	charts, failed, warnings := 0, 0, 0
+	for _, location := range locations {
+		if _, err := os.Stat(location); err != nil {
+			conf.Log.HiRed("* %s", err.Error())
+			return ExitFatal
+		}
+		conf.Input = location
+		songs, err := conf.DiscoverSongFolders()
+		if err != nil {
+			conf.Log.HiRed("* Failed to read %s: %s", location, err.Error())
+			return ExitFatal
+		}
+		if len(songs) == 0 {
+			conf.Log.HiYellow("* No .bms, .bme or .bml files were found in %s", location)
+		}
+		for _, song := range songs {
+			c, f, w := conf.validateSongFolder(song)
+			charts, failed, warnings = charts+c, failed+f, warnings+w
+		}
+	}
+
+	summary := color.HiGreenString("%d charts are valid", charts-failed)
+	if failed > 0 {
+		summary += ", " + color.HiRedString("%d can't be converted", failed)
+	}
+	if warnings > 0 {
+		summary += ", " + color.HiYellowString("%d warnings", warnings)
+	}
+	conf.Log.White("* %s", summary)
+	if failed > 0 || (conf.Strict && warnings > 0) {
+		return ExitChartsFailed
+	}
+	return ExitOK
+}
+
+// validateSongFolder parses every chart of the song folder, printing their problems, and returns how many charts there
+// are, how many can't be converted and how many warnings were found.
+func (conf *ProgramConfig) validateSongFolder(song SongFolder) (int, int, int) {
+	input, closeInput, err := OpenSongFolder(song)
+	if err != nil {
+		conf.Log.HiRed("* Failed to read %s: %s", song.Relative, err.Error())
+		return len(song.Charts), len(song.Charts), 0
+	}
+	defer func() {
+		_ = closeInput()
+	}()
+	folderOptions, err := ReadFolderConfig(input)
+	if err == nil && folderOptions != nil {
+		var folderConf *ProgramConfig
+		if folderConf, err = conf.WithFolderConfig(folderOptions); err == nil {
+			conf = folderConf
+		}
+	}
+	if err != nil {
+		conf.Log.HiRed("* %s of %s is invalid: %s", FolderConfigFile, song.Relative, err.Error())
+		return len(song.Charts), len(song.Charts), 0
+	}
+
+	failed, warnings := 0, 0
+	for _, chart := range song.Charts {
+		// A single chart given on the command line is its own song folder, whose name already ends with the chart.
+		name := song.Relative
+		if path.Base(name) != chart {
+			name = path.Join(song.Relative, chart)
+		}
+		fileData, err := conf.ReadFileData(input, chart)
+		if err != nil {
+			failed++
+			conf.Log.HiRed("* %s: %s", name, err.Error())
+			continue
+		}
+		// Missing files are already warnings of the chart.
+		problems := fileData.Warnings.Strings()
+		warnings += len(problems)
+		if len(problems) == 0 {
+			if conf.Verbose {
+				conf.Log.HiGreen("* %s: OK", name)
+			}
+			continue
+		}
+		conf.Log.HiYellow("* %s: %d warnings", name, len(problems))
+		for _, p := range problems {
+			conf.Log.HiYellow("  * %s", p)
+		}
+		if conf.Verbose {
+			for _, u := range fileData.Assets.Unused {
+				conf.Log.HiBlack("  * %s (%s) is never used", u.Header, u.File)
+			}
+		}
+	}
+	return len(song.Charts), failed, warnings
+}
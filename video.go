@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"math"
+	"path"
+	"strings"
+)
+
+// BackgroundVideo is the video BGA chosen to play in the background.
+type BackgroundVideo struct {
+	// File is the location of the video, relative to the chart.
+	File string `json:"file"`
+
+	// StartTime is the time, in milliseconds, when the video starts playing.
+	StartTime float64 `json:"start_time"`
+
+	// Extension is the extension matching the video's actual container (e.g. ".mp4" for an .mpg file which is
+	// really MP4). Empty if the container couldn't be determined.
+	Extension string `json:"extension"`
+}
+
+// videoSignatures maps the magic bytes of video containers (and where they are found) to the extensions they can
+// have. The first extension is used when a video has to be relabeled.
+var videoSignatures = []struct {
+	offset     int
+	magic      []byte
+	extensions []string
+}{
+	{4, []byte("ftyp"), []string{".mp4", ".m4v"}},
+	{0, []byte{0x00, 0x00, 0x01, 0xBA}, []string{".mpg", ".mpeg"}},
+	{0, []byte{0x00, 0x00, 0x01, 0xB3}, []string{".mpg", ".mpeg"}},
+	{8, []byte("AVI "), []string{".avi"}},
+	{0, []byte{0x30, 0x26, 0xB2, 0x75, 0x8E, 0x66, 0xCF, 0x11}, []string{".wmv", ".asf"}},
+	{0, []byte{0x1A, 0x45, 0xDF, 0xA3}, []string{".webm", ".mkv"}},
+	{0, []byte("FLV"), []string{".flv"}},
+}
+
+// IsVideoFile returns true if the BGA frame is a video rather than an image.
+func IsVideoFile(file string) bool {
+	vExt := strings.ToLower(path.Ext(file))
+	return vExt == ".wmv" || vExt == ".mpg" || vExt == ".mpeg" || vExt == ".avi" || vExt == ".mp4" || vExt == ".webm" || vExt == ".mkv" || vExt == ".flv"
+}
+
+// SniffVideoContainer reads the start of a video and returns the extension of its container, or an empty string if
+// it isn't recognized. The video's own extension is returned if it fits the container (e.g. .mkv and .webm share a
+// signature).
+func SniffVideoContainer(input fs.FS, name string) string {
+	f, e := input.Open(FSPath(name))
+	if e != nil {
+		return ""
+	}
+	defer f.Close()
+	header := make([]byte, 16)
+	n, _ := io.ReadFull(f, header)
+	header = header[:n]
+	for _, s := range videoSignatures {
+		if len(header) < s.offset+len(s.magic) || !bytes.Equal(header[s.offset:s.offset+len(s.magic)], s.magic) {
+			continue
+		}
+		for _, ext := range s.extensions {
+			if strings.EqualFold(path.Ext(name), ext) {
+				return path.Ext(name)
+			}
+		}
+		return s.extensions[0]
+	}
+	return ""
+}
+
+// SelectBackgroundVideo removes all video frames from the chart's BGA frames, and picks the one displayed the
+// longest (until the chart ends) as the chart's background video. osu! can only play one video, so every other video
+// is reported as dropped, at the line which first shows it.
+func (conf *ProgramConfig) SelectBackgroundVideo(input fs.FS, fileData *BMSFileData) {
+	shownFor := map[string]float64{}
+	firstShown := map[string]float64{}
+	firstLine := map[string]int{}
+	var order []string
+	chartEndTime := getChartEndTime(*fileData)
+	frames := make([]BGAFrame, 0, len(fileData.BGAFrames))
+	for i, f := range fileData.BGAFrames {
+		if !IsVideoFile(f.File) {
+			frames = append(frames, f)
+			continue
+		}
+		// A video is shown until another frame replaces it on the same layer, or the chart ends.
+		end := chartEndTime
+		for _, next := range fileData.BGAFrames[i+1:] {
+			if next.Layer == f.Layer {
+				end = math.Min(end, next.StartTime)
+				break
+			}
+		}
+		if _, ok := firstShown[f.File]; !ok {
+			firstShown[f.File] = f.StartTime
+			firstLine[f.File] = f.Line
+			order = append(order, f.File)
+		}
+		shownFor[f.File] += math.Max(end-f.StartTime, 0)
+	}
+	fileData.BGAFrames = frames
+	if len(order) == 0 {
+		return
+	}
+
+	chosen := order[0]
+	for _, v := range order[1:] {
+		if shownFor[v] > shownFor[chosen] {
+			chosen = v
+		}
+	}
+	fileData.Video = &BackgroundVideo{
+		File:      chosen,
+		StartTime: firstShown[chosen],
+		Extension: SniffVideoContainer(input, chosen),
+	}
+	for _, v := range order {
+		if v != chosen {
+			fileData.DroppedVideos = append(fileData.DroppedVideos, v)
+			fileData.Warnings.Add(firstLine[v], 0, "only one video can be used; using %s and dropping %s", chosen, v)
+		}
+	}
+}
+
+// RelabelVideos adds videos whose extension doesn't match their container to the archive with the right extension.
+// Relabeled videos are added to replaced, so references to them can be rewritten (see RewriteAssetReferences).
+func RelabelVideos(input fs.FS, archive ArchiveWriter, videos []BackgroundVideo, replaced map[string]string) error {
+	for _, v := range videos {
+		if len(v.Extension) == 0 || strings.EqualFold(path.Ext(v.File), v.Extension) {
+			continue
+		}
+		target := strings.TrimSuffix(v.File, path.Ext(v.File)) + v.Extension
+		if FileExistsIn(input, target) {
+			continue
+		}
+		if !archive.Exists(target) {
+			if e := archive.Link(target, input, v.File); e != nil {
+				return e
+			}
+		}
+		replaced[v.File] = target
+	}
+	return nil
+}
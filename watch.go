@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"time"
+)
+
+// WatchInput keeps converting song folders which are added to or changed in the input directory, until the program
+// is interrupted. The input is polled (every -watch-interval) instead of relying on file system events, so it also
+// works on network shares. Polls only compare the size and modification time of files (see FolderStamp); the
+// contents are only hashed when a changed folder is converted, which skips it if only its files' dates changed. A
+// folder is only converted once it stopped changing between two polls, so folders that are still being copied aren't
+// converted halfway. When interrupted during a conversion, the folders of that batch are finished first.
+func (conf *ProgramConfig) WatchInput() int {
+	cache := LoadCache(conf.Output)
+	if conf.Force {
+		cache.Folders = map[string]CacheEntry{}
+	}
+	// Stamps of folders as of the last poll, and as of when they were last converted.
+	seen := map[string]string{}
+	converted := map[string]string{}
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	defer signal.Stop(interrupted)
+
+	conf.Log.HiBlue("* Watching %s for changes every %s. Press Ctrl+C to stop.", conf.Input, conf.WatchInterval)
+	for {
+		songs, err := conf.DiscoverSongFolders()
+		if err != nil {
+			conf.Log.HiRed("* Failed to read the input directory: %s", err.Error())
+		}
+		ready := make([]SongFolder, 0)
+		readyStamps := make([]string, 0)
+		for _, song := range songs {
+			stamp, err := FolderStamp(song)
+			if err != nil {
+				// Most likely still being copied; try again on the next poll.
+				delete(seen, song.Relative)
+				continue
+			}
+			previous, wasSeen := seen[song.Relative]
+			seen[song.Relative] = stamp
+			if converted[song.Relative] == stamp {
+				continue
+			}
+			if !wasSeen || previous != stamp {
+				if conf.Verbose {
+					conf.Log.HiBlack("* %s changed; waiting until it stops changing", song.Relative)
+				}
+				continue
+			}
+			ready = append(ready, song)
+			readyStamps = append(readyStamps, stamp)
+		}
+
+		if len(ready) > 0 {
+			conf.Log.White("* [%s] Converting %d song folders", time.Now().Format("15:04:05"), len(ready))
+			// Conversions can't be stopped halfway, so an interrupt only stops the program once they are done.
+			converting := make(chan struct{})
+			stopping := make(chan bool, 1)
+			go func() {
+				select {
+				case <-interrupted:
+					conf.Log.HiYellow("* Stopping once the %d song folders being converted are done. Press Ctrl+C again to stop now.", len(ready))
+					signal.Stop(interrupted)
+					stopping <- true
+				case <-converting:
+					stopping <- false
+				}
+			}()
+			conversionStatus := conf.ConvertSongFolders(ready, cache)
+			close(converting)
+			for i, song := range ready {
+				converted[song.Relative] = readyStamps[i]
+			}
+			cache.Prune(songs)
+			if err := cache.Save(conf.Output); err != nil {
+				conf.Log.HiYellow("* Failed to save %s: %s", CacheFile, err.Error())
+			}
+			conf.PrintSummary(conversionStatus)
+			if len(conf.Report) > 0 {
+				if err := WriteReport(conf.Report, conversionStatus); err != nil {
+					conf.Log.HiRed("* Failed to write the report to %s: %s", conf.Report, err.Error())
+				}
+			}
+			if <-stopping {
+				conf.Log.HiBlue("* Stopped watching %s", conf.Input)
+				return ExitOK
+			}
+			conf.Log.HiBlue("* [%s] Waiting for changes", time.Now().Format("15:04:05"))
+		}
+
+		select {
+		case <-interrupted:
+			conf.Log.HiBlue("* Stopped watching %s", conf.Input)
+			return ExitOK
+		case <-time.After(conf.WatchInterval):
+		}
+	}
+}
@@ -2,90 +2,134 @@ package main
 
 import (
 	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
-func RecursiveMultiPathZip(path1, path2, destinationPath string) error {
-	destinationFile, err := os.Create(destinationPath)
-	if err != nil {
-		return err
+// storedExtensions are formats which are already compressed, so deflating them again gains nothing.
+var storedExtensions = map[string]bool{
+	".ogg":  true,
+	".mp3":  true,
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".webp": true,
+}
+
+// zipTimestamp is the modification time given to every file in an archive, so archives are reproducible.
+var zipTimestamp = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// WriteZip zips every file of the archive, along with the contents of the input. Files in the archive take
+// precedence over files with the same relative location in the input, and any relative location in exclude is left
+// out. Entries are sorted and timestamps are fixed, so the same input always creates the same archive.
+// The zip is written next to destinationPath and only moved there once complete. Returns the SHA-256 hash of the zip.
+func WriteZip(archive ArchiveWriter, input fs.FS, destinationPath string, exclude map[string]string) (string, error) {
+	// Relative location -> whether the file is in the archive (true) or the input (false).
+	files := map[string]bool{}
+	for _, name := range archive.Files() {
+		files[name] = true
 	}
-	myZip := zip.NewWriter(destinationFile)
-	err = walkPath(path1, myZip)
+	err := fs.WalkDir(input, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if _, ok := files[name]; !ok && !d.IsDir() {
+			files[name] = false
+		}
+		return nil
+	})
 	if err != nil {
-		return err
+		return "", err
 	}
-	err = walkPath(path2, myZip)
-	if err != nil {
-		return err
+	for k := range exclude {
+		delete(files, k)
 	}
-	err = myZip.Close()
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	destinationFile, err := os.CreateTemp(filepath.Dir(destinationPath), "."+filepath.Base(destinationPath)+".*.tmp")
 	if err != nil {
-		return err
+		return "", err
 	}
-	return nil
-}
+	tempPath := destinationFile.Name()
+	defer os.Remove(tempPath)
+	defer destinationFile.Close()
 
-func walkPath(path string, z *zip.Writer) error {
-	return filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
-		if info == nil || info.IsDir() {
-			return nil
-		}
-		if err != nil {
-			return err
+	hash := sha256.New()
+	myZip := zip.NewWriter(io.MultiWriter(destinationFile, hash))
+	for _, name := range names {
+		var source io.ReadCloser
+		if files[name] {
+			source, err = archive.Open(name)
+		} else {
+			source, err = input.Open(name)
 		}
-		relPath := strings.TrimPrefix(filepath.FromSlash(filePath), filepath.FromSlash(path)+string(os.PathSeparator))
-		zipFile, err := z.Create(relPath)
 		if err != nil {
-			return err
+			return "", err
 		}
-		fsFile, err := os.Open(filePath)
+		err = addToZip(myZip, name, source)
+		source.Close()
 		if err != nil {
-			return err
+			return "", err
 		}
-		defer fsFile.Close()
-		_, err = io.Copy(zipFile, fsFile)
-		if err != nil {
-			return err
-		}
-		return nil
-	})
+	}
+	if err = myZip.Close(); err != nil {
+		return "", err
+	}
+	if err = destinationFile.Close(); err != nil {
+		return "", err
+	}
+	if err = os.Rename(tempPath, destinationPath); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
-// CopyPath replicates every file under srcDir into dstDir, preserving subfolders.
-func CopyPath(srcDir, dstDir string) error {
-	return filepath.Walk(srcDir, func(srcPath string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
+func addToZip(z *zip.Writer, name string, source io.Reader) error {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: zipTimestamp,
+	}
+	if ext := strings.ToLower(path.Ext(name)); storedExtensions[ext] || IsVideoFile(name) {
+		header.Method = zip.Store
+	}
+	zipFile, err := z.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(zipFile, source)
+	return err
+}
 
-		rel, err := filepath.Rel(srcDir, srcPath)
+// CopyPath replicates every file of the input into dstDir, preserving subfolders.
+// Files already in dstDir are kept, and any relative location in exclude is skipped.
+func CopyPath(input fs.FS, dstDir string, exclude map[string]string) error {
+	return fs.WalkDir(input, ".", func(name string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		dstPath := filepath.Join(dstDir, rel)
-
-		if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
-			return err
+		if d.IsDir() {
+			return nil
 		}
-
-		in, err := os.Open(srcPath)
-		if err != nil {
-			return err
+		if _, ok := exclude[name]; ok {
+			return nil
 		}
-		defer in.Close()
-		out, err := os.Create(dstPath)
-		if err != nil {
-			return err
+		dstPath := filepath.Join(dstDir, filepath.FromSlash(name))
+		if FileExists(dstPath) {
+			return nil
 		}
-		defer out.Close()
-		_, err = io.Copy(out, in)
-		return err
+		return copyFile(input, name, dstPath)
 	})
 }